@@ -160,13 +160,60 @@ async function uploadVideoFile(videoID) {
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log("Video uploaded!");
+    console.log("Video uploaded, processing...");
+    await watchVideoEvents(videoID);
+    console.log("Video processed!");
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
 }
 
+// Reads the server-sent processing events for a video until it is ready or
+// has failed. fetch is used instead of EventSource so the JWT can be sent.
+async function watchVideoEvents(videoID) {
+  const progress = document.getElementById("video-progress");
+  const res = await fetch(`/api/videos/${videoID}/events`, {
+    headers: {
+      Authorization: `Bearer ${localStorage.getItem("token")}`,
+    },
+  });
+  if (!res.ok) {
+    const data = await res.json();
+    throw new Error(`Failed to watch video processing. Error: ${data.error}`);
+  }
+
+  const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  while (true) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += value;
+    const messages = buffer.split("\n\n");
+    buffer = messages.pop();
+    for (const message of messages) {
+      const dataLine = message
+        .split("\n")
+        .find((line) => line.startsWith("data: "));
+      if (!dataLine) {
+        continue;
+      }
+      const event = JSON.parse(dataLine.slice(6));
+      progress.textContent = `${event.stage} ${Math.round(event.percent)}%`;
+      if (event.stage === "ready") {
+        progress.textContent = "";
+        return;
+      }
+      if (event.stage === "failed") {
+        progress.textContent = "";
+        throw new Error(`Failed to process video. Error: ${event.message}`);
+      }
+    }
+  }
+}
+
 async function getVideos() {
   try {
     const res = await fetch("/api/videos", {
@@ -228,6 +275,7 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = "block";
     thumbnailImg.src = video.thumbnail_url;
+    thumbnailImg.srcset = video.thumbnail_srcset?.["image/jpeg"] ?? "";
   }
 
   const downloadButton = document.getElementById("download-button");
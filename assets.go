@@ -1,24 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
-)
 
-func (cfg apiConfig) ensureAssetsDir() error {
-	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
-		return os.Mkdir(cfg.assetsRoot, 0755)
-	}
-	return nil
-}
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
 
 func getAssetPath(mediaType string) string {
 	base := make([]byte, 32)
@@ -32,6 +30,25 @@ func getAssetPath(mediaType string) string {
 	return fmt.Sprintf("%s%s", id, ext)
 }
 
+// getVideoKey returns the object key for a video stored as assetName (from
+// getAssetPath), prefixed by its orientation, e.g. "landscape/<random>.mp4".
+func getVideoKey(aspectRatio, assetName string) string {
+	prefix := "other"
+	switch aspectRatio {
+	case "16:9":
+		prefix = "landscape"
+	case "9:16":
+		prefix = "portrait"
+	}
+	return path.Join(prefix, assetName)
+}
+
+// videoPrefix is the key prefix everything derived from a video (streaming
+// renditions, previews) is stored under: its key without the extension.
+func videoPrefix(videoKey string) string {
+	return strings.TrimSuffix(videoKey, path.Ext(videoKey))
+}
+
 func mediaTypeToExt(mediaType string) string {
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
@@ -40,127 +57,182 @@ func mediaTypeToExt(mediaType string) string {
 	return "." + parts[1]
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+// uploadVideoTypes are the Content-Types accepted for video uploads. They're
+// only a first filter: the container is sniffed with ffprobe before
+// processing.
+var uploadVideoTypes = map[string]bool{
+	"video/mp4":                true,
+	"video/quicktime":          true,
+	"video/webm":               true,
+	"video/x-matroska":         true,
+	"video/x-msvideo":          true,
+	"video/avi":                true,
+	"video/vnd.avi":            true,
+	"application/octet-stream": true,
+}
+
+// errUnsupportedContainer is returned for uploads ffprobe recognises as
+// something other than a supported video container.
+var errUnsupportedContainer = errors.New("unsupported video container")
+
+// checkContainer reports whether ffprobe's format_name for a file is one of
+// the containers we accept: MP4/QuickTime, Matroska/WebM or AVI.
+func checkContainer(formatName string) error {
+	for _, name := range strings.Split(formatName, ",") {
+		switch name {
+		case "mov", "mp4", "matroska", "webm", "avi":
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", errUnsupportedContainer, formatName)
+}
+
+// isStreamableMP4 reports whether a video can be remuxed into our MP4s as is:
+// an MP4 file with H.264 video and AAC audio, if it has any. ffprobe names
+// every ISO base media file "mov,mp4,m4a,3gp,3g2,mj2", so the major brand is
+// what tells an MP4 apart from a QuickTime movie ("qt").
+func isStreamableMP4(metadata database.VideoMetadata) bool {
+	return strings.Contains(metadata.Container, "mp4") &&
+		isMP4Brand(metadata.MajorBrand) &&
+		metadata.VideoCodec == "h264" &&
+		(metadata.AudioCodec == "" || metadata.AudioCodec == "aac")
+}
+
+func isMP4Brand(brand string) bool {
+	switch brand {
+	case "isom", "iso2", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V", "M4VP", "dash":
+		return true
+	}
+	return false
+}
+
+// processVideoToMP4 transcodes a video in any supported container into a
+// faststart H.264/AAC MP4, keeping only its primary video stream and first
+// audio stream.
+func processVideoToMP4(ctx context.Context, filePath string, progress ffmpegProgressFunc) (string, error) {
+	outputPath := filePath + ".processing"
+	err := runFFmpeg(ctx, filePath, progress,
+		"-i", filePath,
+		"-map", "0:V:0",
+		"-map", "0:a:0?",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-movflags", "faststart",
+		"-f", "mp4",
+		outputPath,
+	)
 	if err != nil {
-		fmt.Println("The command didn't run")
-		fmt.Printf("Error: %v\n", err)
-		fmt.Printf("Stderr: %s\n", stderr.String())
 		return "", err
 	}
-	fmt.Println("The command ran")
-
-	type parameters struct {
-		Streams []struct {
-			Index              int    `json:"index"`
-			CodecName          string `json:"codec_name,omitempty"`
-			CodecLongName      string `json:"codec_long_name,omitempty"`
-			Profile            string `json:"profile,omitempty"`
-			CodecType          string `json:"codec_type"`
-			CodecTagString     string `json:"codec_tag_string"`
-			CodecTag           string `json:"codec_tag"`
-			Width              int    `json:"width,omitempty"`
-			Height             int    `json:"height,omitempty"`
-			CodedWidth         int    `json:"coded_width,omitempty"`
-			CodedHeight        int    `json:"coded_height,omitempty"`
-			ClosedCaptions     int    `json:"closed_captions,omitempty"`
-			HasBFrames         int    `json:"has_b_frames,omitempty"`
-			SampleAspectRatio  string `json:"sample_aspect_ratio,omitempty"`
-			DisplayAspectRatio string `json:"display_aspect_ratio,omitempty"`
-			PixFmt             string `json:"pix_fmt,omitempty"`
-			Level              int    `json:"level,omitempty"`
-			ColorRange         string `json:"color_range,omitempty"`
-			ColorSpace         string `json:"color_space,omitempty"`
-			ColorTransfer      string `json:"color_transfer,omitempty"`
-			ColorPrimaries     string `json:"color_primaries,omitempty"`
-			ChromaLocation     string `json:"chroma_location,omitempty"`
-			Refs               int    `json:"refs,omitempty"`
-			IsAvc              string `json:"is_avc,omitempty"`
-			NalLengthSize      string `json:"nal_length_size,omitempty"`
-			RFrameRate         string `json:"r_frame_rate"`
-			AvgFrameRate       string `json:"avg_frame_rate"`
-			TimeBase           string `json:"time_base"`
-			StartPts           int    `json:"start_pts"`
-			StartTime          string `json:"start_time"`
-			DurationTs         int    `json:"duration_ts"`
-			Duration           string `json:"duration"`
-			BitRate            string `json:"bit_rate,omitempty"`
-			BitsPerRawSample   string `json:"bits_per_raw_sample,omitempty"`
-			NbFrames           string `json:"nb_frames"`
-			Disposition        struct {
-				Default         int `json:"default"`
-				Dub             int `json:"dub"`
-				Original        int `json:"original"`
-				Comment         int `json:"comment"`
-				Lyrics          int `json:"lyrics"`
-				Karaoke         int `json:"karaoke"`
-				Forced          int `json:"forced"`
-				HearingImpaired int `json:"hearing_impaired"`
-				VisualImpaired  int `json:"visual_impaired"`
-				CleanEffects    int `json:"clean_effects"`
-				AttachedPic     int `json:"attached_pic"`
-				TimedThumbnails int `json:"timed_thumbnails"`
-			} `json:"disposition"`
-			Tags struct {
-				Language    string `json:"language"`
-				HandlerName string `json:"handler_name"`
-				VendorID    string `json:"vendor_id"`
-				Encoder     string `json:"encoder"`
-				Timecode    string `json:"timecode"`
-			} `json:"tags,omitempty"`
-			SampleFmt     string `json:"sample_fmt,omitempty"`
-			SampleRate    string `json:"sample_rate,omitempty"`
-			Channels      int    `json:"channels,omitempty"`
-			ChannelLayout string `json:"channel_layout,omitempty"`
-			BitsPerSample int    `json:"bits_per_sample,omitempty"`
-			Tags0         struct {
-				Language    string `json:"language"`
-				HandlerName string `json:"handler_name"`
-				VendorID    string `json:"vendor_id"`
-			} `json:"tags,omitempty"`
-			Tags1 struct {
-				Language    string `json:"language"`
-				HandlerName string `json:"handler_name"`
-				Timecode    string `json:"timecode"`
-			} `json:"tags,omitempty"`
-		} `json:"streams"`
-	}
+	return outputPath, nil
+}
 
-	decoder := json.NewDecoder(&out)
-	params := parameters{}
-	err = decoder.Decode(&params)
+func processVideoForFastStart(ctx context.Context, filePath string, progress ffmpegProgressFunc) (string, error) {
+	outputPath := filePath + ".processing"
+	err := runFFmpeg(ctx, filePath, progress,
+		"-i", filePath,
+		"-map", "0:V:0",
+		"-map", "0:a:0?",
+		"-c", "copy",
+		"-movflags", "faststart",
+		"-f", "mp4",
+		outputPath,
+	)
 	if err != nil {
 		return "", err
 	}
+	return outputPath, nil
+}
 
-	const tolerance = 0.01
+// ffmpegProgressFunc receives how much of the input ffmpeg has processed, as
+// a percentage.
+type ffmpegProgressFunc func(percent float64)
 
-	if len(params.Streams) > 0 {
-		width := float64(params.Streams[0].Width)
-		height := float64(params.Streams[0].Height)
-		ratio := width / height
+// runFFmpeg runs ffmpeg with args, overwriting any existing output. If
+// progress is set it is fed from ffmpeg's -progress output, measured against
+// the duration of inputPath.
+func runFFmpeg(ctx context.Context, inputPath string, progress ffmpegProgressFunc, args ...string) error {
+	fullArgs := append([]string{"-y", "-nostats", "-loglevel", "error"}, args...)
 
-		if math.Abs(ratio-(16.0/9.0)) < tolerance {
-			return "16:9", nil
-		} else if math.Abs(ratio-(9.0/16.0)) < tolerance {
-			return "9:16", nil
+	var duration float64
+	if progress != nil {
+		d, err := getVideoDuration(inputPath)
+		if err != nil {
+			log.Printf("Couldn't get duration of %s, progress won't be reported: %v", inputPath, err)
+		} else {
+			duration = d
+			fullArgs = append([]string{"-progress", "pipe:1"}, fullArgs...)
 		}
 	}
-	return "other", nil
-}
 
-func processVideoForFastStart(filePath string) (string, error) {
-	outputPath := filePath + ".processing"
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", fullArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	log.Printf("Running command: %v", cmd.String())
-	err := cmd.Run()
+
+	if duration <= 0 {
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return "", err
+		return err
 	}
-	return outputPath, nil
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	lastPercent := -1.0
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		var percent float64
+		switch key {
+		case "out_time_us":
+			us, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			percent = math.Min(100, us/1e6/duration*100)
+		case "progress":
+			if value != "end" {
+				continue
+			}
+			percent = 100
+		default:
+			continue
+		}
+		if math.Floor(percent) > math.Floor(lastPercent) {
+			lastPercent = percent
+			progress(math.Floor(percent))
+		}
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// getVideoDuration returns the duration of a media file in seconds.
+func getVideoDuration(filePath string) (float64, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestIsStreamableMP4(t *testing.T) {
+	const isoBMFF = "mov,mp4,m4a,3gp,3g2,mj2"
+
+	tests := []struct {
+		name     string
+		metadata database.VideoMetadata
+		want     bool
+	}{
+		{"mp4", database.VideoMetadata{Container: isoBMFF, MajorBrand: "isom", VideoCodec: "h264", AudioCodec: "aac"}, true},
+		{"mp4 without audio", database.VideoMetadata{Container: isoBMFF, MajorBrand: "mp42", VideoCodec: "h264"}, true},
+		{"quicktime", database.VideoMetadata{Container: isoBMFF, MajorBrand: "qt", VideoCodec: "h264", AudioCodec: "aac"}, false},
+		{"no brand", database.VideoMetadata{Container: isoBMFF, VideoCodec: "h264", AudioCodec: "aac"}, false},
+		{"hevc", database.VideoMetadata{Container: isoBMFF, MajorBrand: "isom", VideoCodec: "hevc", AudioCodec: "aac"}, false},
+		{"opus audio", database.VideoMetadata{Container: isoBMFF, MajorBrand: "isom", VideoCodec: "h264", AudioCodec: "opus"}, false},
+		{"matroska", database.VideoMetadata{Container: "matroska,webm", VideoCodec: "h264", AudioCodec: "aac"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isStreamableMP4(tt.metadata)
+			if got != tt.want {
+				t.Errorf("isStreamableMP4 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// Command flag-shared-keys is a one-off migration for videos uploaded before
+// object keys were unique per video. Those uploads were all written to one
+// of a few fixed keys, so each upload overwrote the previous one of the same
+// orientation. Every video row pointing at one of those keys has its video
+// URL cleared and is flagged as needing re-upload.
+//
+// Usage:
+//
+//	go run ./cmd/flag-shared-keys [-dry-run]
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/joho/godotenv"
+)
+
+var sharedVideoKeys = []string{
+	"landscape/horizontal.mp4",
+	"portrait/vertical.mp4",
+	"other.mp4",
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report affected videos")
+	flag.Parse()
+
+	godotenv.Load(".env")
+
+	pathToDB := os.Getenv("DB_PATH")
+	if pathToDB == "" {
+		log.Fatal("DB_PATH must be set")
+	}
+
+	db, err := database.NewClient(pathToDB)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	total := 0
+	for _, key := range sharedVideoKeys {
+		if *dryRun {
+			count, err := db.CountVideosWithKey(key)
+			if err != nil {
+				log.Fatalf("Couldn't count videos stored at %s: %v", key, err)
+			}
+			log.Printf("%d videos stored at %s", count, key)
+			total += count
+			continue
+		}
+
+		count, err := db.FlagVideosWithKey(key)
+		if err != nil {
+			log.Fatalf("Couldn't flag videos stored at %s: %v", key, err)
+		}
+		log.Printf("Flagged %d videos stored at %s", count, key)
+		total += int(count)
+	}
+
+	if *dryRun {
+		log.Printf("Dry run: %d videos would be flagged for re-upload", total)
+		return
+	}
+	log.Printf("Flagged %d videos for re-upload", total)
+}
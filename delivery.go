@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+)
+
+const (
+	deliveryPresign    = "presign"
+	deliveryCloudFront = "cloudfront"
+)
+
+type cdnConfig struct {
+	domain string
+	signer *cdn.Signer
+	policy string
+	ttl    time.Duration
+}
+
+// cdnDomain normalizes S3_CF_DISTRO, which may be given as a bare domain or
+// as a full https:// URL.
+func cdnDomain(distribution string) string {
+	domain := strings.TrimPrefix(distribution, "https://")
+	domain = strings.TrimPrefix(domain, "http://")
+	return strings.TrimSuffix(domain, "/")
+}
+
+// cdnURL builds the edge URL for key. When a CloudFront key pair is
+// configured the URL is signed, otherwise the distribution is assumed to be
+// publicly readable.
+func (c *cdnConfig) cdnURL(key string) (string, error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   c.domain,
+		Path:   "/" + key,
+	}
+	rawURL := u.String()
+	if c.signer == nil {
+		return rawURL, nil
+	}
+
+	now := time.Now().UTC()
+	switch c.policy {
+	case "custom":
+		return c.signer.SignCustom(rawURL, cdn.Policy{
+			Resource:        rawURL,
+			DateGreaterThan: now.Add(-5 * time.Minute),
+			DateLessThan:    now.Add(c.ttl),
+		})
+	case "", "canned":
+		return c.signer.SignCanned(rawURL, now.Add(c.ttl))
+	default:
+		return "", fmt.Errorf("unknown CloudFront policy type %q", c.policy)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	stageQueued      = "queued"
+	stageProbing     = "probing"
+	stageFastStart   = "faststart"
+	stageTranscoding = "transcoding"
+	stageStoring     = "storing"
+	stageThumbnail   = "thumbnail"
+	stagePreviews    = "previews"
+	stageRetrying    = "retrying"
+	stageReady       = "ready"
+	stageFailed      = "failed"
+)
+
+// videoEvent is a progress update for one stage of processing a video.
+type videoEvent struct {
+	Stage   string  `json:"stage"`
+	Percent float64 `json:"percent"`
+	Bytes   int64   `json:"bytes,omitempty"`
+	Total   int64   `json:"total,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// eventBroker fans processing events out to everyone watching a video.
+// Events are best effort: a subscriber that falls behind misses updates
+// rather than stalling the processing pipeline.
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[chan videoEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subs: map[uuid.UUID]map[chan videoEvent]struct{}{},
+	}
+}
+
+func (b *eventBroker) subscribe(videoID uuid.UUID) (<-chan videoEvent, func()) {
+	ch := make(chan videoEvent, 64)
+
+	b.mu.Lock()
+	if b.subs[videoID] == nil {
+		b.subs[videoID] = map[chan videoEvent]struct{}{}
+	}
+	b.subs[videoID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[videoID], ch)
+		if len(b.subs[videoID]) == 0 {
+			delete(b.subs, videoID)
+		}
+	}
+	return ch, unsubscribe
+}
+
+func (b *eventBroker) publish(videoID uuid.UUID, event videoEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[videoID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
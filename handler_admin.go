@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type adminUser struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Email      string        `json:"email"`
+	Role       database.Role `json:"role"`
+	DisabledAt *time.Time    `json:"disabled_at"`
+}
+
+func newAdminUser(user database.User) adminUser {
+	return adminUser{
+		ID:         user.ID,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		Email:      user.Email,
+		Role:       user.Role,
+		DisabledAt: user.DisabledAt,
+	}
+}
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users", err)
+		return
+	}
+
+	resp := make([]adminUser, len(users))
+	for i, user := range users {
+		resp[i] = newAdminUser(user)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminUserUpdate changes a user's role or disables their account.
+// Either change logs the user out everywhere and revokes their API keys, so
+// tokens carrying the old role stop working.
+func (cfg *apiConfig) handlerAdminUserUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role     *database.Role `json:"role"`
+		Disabled *bool          `json:"disabled"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if userID == principalFrom(r).UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own account", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role != nil && !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid role %q", *params.Role), nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+
+	revoke := false
+	if params.Role != nil && *params.Role != user.Role {
+		err = cfg.db.SetUserRole(userID, *params.Role)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+			return
+		}
+		revoke = true
+	}
+	if params.Disabled != nil {
+		err = cfg.db.SetUserDisabled(userID, *params.Disabled)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update account", err)
+			return
+		}
+		revoke = revoke || *params.Disabled
+	}
+
+	if revoke {
+		err = cfg.db.RevokeAllSessions(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+		err = cfg.db.RevokeAPIKeys(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API keys", err)
+			return
+		}
+	}
+
+	user, err = cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newAdminUser(*user))
+}
+
+// handlerAdminVideoUnpublish hides any user's video from everyone but its
+// owner, who can still see its details but not its media.
+func (cfg *apiConfig) handlerAdminVideoUnpublish(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoPublished(w, r, false)
+}
+
+func (cfg *apiConfig) handlerAdminVideoPublish(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoPublished(w, r, true)
+}
+
+func (cfg *apiConfig) setVideoPublished(w http.ResponseWriter, r *http.Request, published bool) {
+	video, ok := cfg.getPathVideo(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.SetVideoUnpublished(video.ID, !published)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.dbVideoToSignedVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't sign video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getPathVideo(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.deleteVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	userID := principalFrom(r).UserID
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil || job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find job", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// respondWithJob tells the client its upload was queued and where to poll
+// for the result.
+func respondWithJob(w http.ResponseWriter, job database.Job) {
+	w.Header().Set("Location", fmt.Sprintf("/api/jobs/%s", job.ID))
+	respondWithJSON(w, http.StatusAccepted, job)
+}
@@ -5,11 +5,18 @@ import (
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+// handlerRefresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once: presenting one that has
+// already been rotated means it leaked, so its whole family is revoked.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,14 +25,69 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	rt, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if rt.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	if rt.ReplacedBy != nil {
+		cfg.revokeRefreshTokenFamily(w, rt)
+		return
+	}
+	if rt.RevokedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", nil)
+		return
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(rt.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	nextToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	rotated, err := cfg.db.RotateRefreshToken(rt.Token, database.CreateRefreshTokenParams{
+		Token:     nextToken,
+		UserID:    user.ID,
+		FamilyID:  rt.FamilyID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+	if !rotated {
+		// Another request spent this token between our read and the update.
+		cfg.revokeRefreshTokenFamily(w, rt)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		rt.FamilyID,
+		string(user.Role),
 		cfg.jwtSecret,
 		time.Hour,
 	)
@@ -35,10 +97,22 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: nextToken,
 	})
 }
 
+// revokeRefreshTokenFamily handles reuse of an already rotated refresh
+// token by revoking every token descended from the same login.
+func (cfg *apiConfig) revokeRefreshTokenFamily(w http.ResponseWriter, rt database.RefreshToken) {
+	err := cfg.db.RevokeRefreshTokenFamily(rt.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Refresh token reuse detected, session revoked", nil)
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// handlerSessionsList returns the caller's active sessions, one per device
+// they've logged in on, flagging the one making the request.
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r)
+
+	sessions, err := cfg.db.GetSessions(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == p.SessionID
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handlerSessionRevoke logs one of the caller's devices out.
+func (cfg *apiConfig) handlerSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+
+	revoked, err := cfg.db.RevokeSession(userID, r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Couldn't find session", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsRevokeOthers logs the caller out everywhere except the
+// session making the request.
+func (cfg *apiConfig) handlerSessionsRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r)
+	if p.SessionID == "" {
+		respondWithError(w, http.StatusBadRequest, "Access token isn't tied to a session, log in again", nil)
+		return
+	}
+
+	_, err := cfg.db.RevokeOtherSessions(p.UserID, p.SessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientIP is the address the request came from. Forwarding headers are
+// ignored since they're trivially spoofed without a trusted proxy in front.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable video uploads implementing the tus 1.0 core protocol with the
+// creation, termination and expiration extensions
+// (https://tus.io/protocols/resumable-upload). Upload state lives in the
+// uploads table and the received bytes in a file under uploadsRoot, so an
+// interrupted upload can resume after a restart.
+
+const tusVersion = "1.0.0"
+
+const (
+	// tusUploadTTL is how long an upload may go without receiving data
+	// before it's abandoned.
+	tusUploadTTL = 24 * time.Hour
+	// tusSweepInterval is how often abandoned uploads are removed.
+	tusSweepInterval = time.Hour
+)
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(videoUploadLimit, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > videoUploadLimit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	metadata := r.Header.Get("Upload-Metadata")
+	fields, err := parseTusMetadata(metadata)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	if fileType, ok := fields["filetype"]; ok {
+		mediaType, _, err := mime.ParseMediaType(fileType)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid filetype", err)
+			return
+		}
+		if !uploadVideoTypes[mediaType] {
+			respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+			return
+		}
+	}
+
+	uploadID := uuid.New()
+	filePath := filepath.Join(cfg.uploadsRoot, uploadID.String())
+	file, err := os.Create(filePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	file.Close()
+
+	upload, err := cfg.db.CreateUpload(uploadID, database.CreateUploadParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		Length:    length,
+		Metadata:  metadata,
+		FilePath:  filePath,
+		ExpiresAt: time.Now().Add(tusUploadTTL),
+	})
+	if err != nil {
+		os.Remove(filePath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	cfg.setVideoStatus(video.ID, database.VideoUploading, nil)
+
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Location", fmt.Sprintf("/api/video_upload/%s/tus/%s", video.ID, upload.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+
+	upload, ok := cfg.tusGetUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	upload, ok := cfg.tusGetUpload(w, r)
+	if !ok {
+		return
+	}
+
+	lock := cfg.uploadLock(upload.ID)
+	if !lock.TryLock() {
+		respondWithError(w, http.StatusLocked, "Upload is already being written to", nil)
+		return
+	}
+	defer lock.Unlock()
+
+	// Re-read the row now that we hold the lock in case another request
+	// advanced the offset in the meantime.
+	upload, err := cfg.db.GetUpload(upload.ID)
+	if err != nil || upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	file, err := os.OpenFile(upload.FilePath, os.O_WRONLY, 0644)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	defer file.Close()
+
+	// Drop any bytes written after the last recorded offset, e.g. by a chunk
+	// that was cut off before its offset was saved.
+	err = file.Truncate(upload.Offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+	_, err = file.Seek(upload.Offset, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, upload.Length-upload.Offset))
+	if syncErr := file.Sync(); syncErr != nil && copyErr == nil {
+		copyErr = syncErr
+	}
+	// Keep whatever arrived, even if the connection dropped part way.
+	upload.Offset += written
+	upload.ExpiresAt = time.Now().Add(tusUploadTTL)
+	err = cfg.db.UpdateUploadOffset(upload.ID, upload.Offset, upload.ExpiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write chunk", copyErr)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Offset < upload.Length {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err = file.Close()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload file", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't get the video's metadata from the database", err)
+		return
+	}
+
+	// The finished file now belongs to the processing job.
+	_, err = cfg.enqueueVideoProcessing(video, processVideoPayload{
+		SourcePath: upload.FilePath,
+	})
+	if err != nil {
+		// The upload is left in place so a repeated empty PATCH at the final
+		// offset can retry.
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+		return
+	}
+
+	err = cfg.db.DeleteUpload(upload.ID)
+	if err != nil {
+		log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+	}
+	cfg.uploadLocks.Delete(upload.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	upload, ok := cfg.tusGetUpload(w, r)
+	if !ok {
+		return
+	}
+
+	lock := cfg.uploadLock(upload.ID)
+	if !lock.TryLock() {
+		respondWithError(w, http.StatusLocked, "Upload is already being written to", nil)
+		return
+	}
+	defer lock.Unlock()
+
+	cfg.removeUpload(upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusGetUpload authorizes the request and loads the upload in the path,
+// which must belong to both the video in the path and the caller.
+func (cfg *apiConfig) tusGetUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return database.Upload{}, false
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return database.Upload{}, false
+	}
+
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.Upload{}, false
+	}
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.ID == uuid.Nil || upload.VideoID != video.ID || upload.UserID != video.UserID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", nil)
+		return database.Upload{}, false
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) uploadLock(id uuid.UUID) *sync.Mutex {
+	lock, _ := cfg.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func (cfg *apiConfig) removeUpload(upload database.Upload) {
+	err := cfg.db.DeleteUpload(upload.ID)
+	if err != nil {
+		log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+	}
+	err = os.Remove(upload.FilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Couldn't remove upload file %s: %v", upload.FilePath, err)
+	}
+	cfg.uploadLocks.Delete(upload.ID)
+}
+
+// sweepExpiredUploads removes abandoned uploads every tusSweepInterval until
+// ctx is canceled. Uploads that are being written to are left for the next
+// sweep.
+func (cfg *apiConfig) sweepExpiredUploads(ctx context.Context) {
+	ticker := time.NewTicker(tusSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		uploads, err := cfg.db.GetExpiredUploads(time.Now())
+		if err != nil {
+			log.Printf("Couldn't get expired uploads: %v", err)
+		}
+		removed := 0
+		for _, upload := range uploads {
+			lock := cfg.uploadLock(upload.ID)
+			if !lock.TryLock() {
+				continue
+			}
+			// A chunk may have arrived since the query ran.
+			current, err := cfg.db.GetUpload(upload.ID)
+			if err == nil && current.ID != uuid.Nil && time.Now().After(current.ExpiresAt) {
+				cfg.removeUpload(current)
+				removed++
+			}
+			lock.Unlock()
+		}
+		if removed > 0 {
+			log.Printf("Removed %d expired uploads", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated pairs
+// of a key and an optional base64-encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	fields := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return fields, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			fields[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %q: %w", parts[0], err)
+			}
+			fields[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("malformed pair %q", pair)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const directUploadExpiry = 15 * time.Minute
+
+// stagingKey is where a direct browser upload for a video lands before it
+// has been processed. Each presigned upload gets its own uploadID so two
+// uploads started for the same video can't overwrite each other.
+func stagingKey(videoID, uploadID uuid.UUID) string {
+	return path.Join(stagingPrefix(videoID), uploadID.String())
+}
+
+// stagingPrefix holds every direct upload staged for a video.
+func stagingPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("staging/%s/", videoID)
+}
+
+func (cfg *apiConfig) handlerDirectUploadCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Method      string `json:"method"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	type response struct {
+		UploadID  uuid.UUID         `json:"upload_id"`
+		Method    string            `json:"method"`
+		URL       string            `json:"url"`
+		Fields    map[string]string `json:"fields,omitempty"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	uploader, ok := cfg.store.(storage.DirectUploader)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid content_type", err)
+		return
+	}
+	if !uploadVideoTypes[mediaType] {
+		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+		return
+	}
+	if params.Size < 0 || params.Size > videoUploadLimit {
+		respondWithError(w, http.StatusBadRequest, "Invalid size", nil)
+		return
+	}
+
+	uploadID := uuid.New()
+	key := stagingKey(video.ID, uploadID)
+	expiresAt := time.Now().UTC().Add(directUploadExpiry)
+	resp := response{
+		UploadID:  uploadID,
+		Method:    params.Method,
+		ExpiresAt: expiresAt,
+	}
+
+	switch params.Method {
+	case "", "put":
+		// A PUT can't carry a size range the way a POST policy does, so the
+		// exact size is signed into it.
+		if params.Size == 0 {
+			respondWithError(w, http.StatusBadRequest, "size is required for put uploads", nil)
+			return
+		}
+		resp.Method = "put"
+		resp.URL, err = uploader.PresignPut(r.Context(), key, mediaType, params.Size, directUploadExpiry)
+	case "post":
+		var post storage.PresignedPost
+		post, err = uploader.PresignPost(r.Context(), key, mediaType, videoUploadLimit, directUploadExpiry)
+		resp.URL = post.URL
+		resp.Fields = post.Fields
+	default:
+		respondWithError(w, http.StatusBadRequest, "method must be put or post", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	cfg.setVideoStatus(video.ID, database.VideoUploading, nil)
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerDirectUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UploadID uuid.UUID `json:"upload_id"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.UploadID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "upload_id is required", nil)
+		return
+	}
+
+	key := stagingKey(video.ID, params.UploadID)
+	info, err := cfg.store.Stat(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusConflict, "No upload found for this video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check staged upload", err)
+		return
+	}
+	if info.Size > videoUploadLimit {
+		cfg.store.Delete(r.Context(), key)
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	job, err := cfg.enqueueVideoProcessing(video, processVideoPayload{
+		StagingKey: key,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+		return
+	}
+
+	respondWithJob(w, job)
+}
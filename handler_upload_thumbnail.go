@@ -1,47 +1,35 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
-	}
-
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
-	// TODO: implement the upload here
+	// Leave room for the multipart framing around the image itself.
+	r.Body = http.MaxBytesReader(w, r.Body, thumbnailMaxBytes+1<<20)
 	const maxMemory = 10 << 20
 
-	err = r.ParseMultipartForm(maxMemory)
+	err := r.ParseMultipartForm(maxMemory)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't parse request body as multipart form", err)
+		respondWithError(w, http.StatusBadRequest, "couldn't parse request body as multipart form", err)
 		return
 	}
 
@@ -62,59 +50,87 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	/*
-		data, err := io.ReadAll(file)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "couldn't read the image data", err)
-			return
-		}
-	*/
-
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't get the video's metadata from the database", err)
+	data, mediaType, err := normalizeImage(file, mediaType)
+	if errors.Is(err, errInvalidImage) {
+		respondWithError(w, http.StatusBadRequest, "Invalid image", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process image", err)
 		return
 	}
 
-	mySlice := make([]byte, 32)
-	_, err = rand.Read(mySlice)
+	fileKey := path.Join(thumbnailPrefix(video), getAssetPath(mediaType))
+	err = cfg.store.Put(r.Context(), fileKey, bytes.NewReader(data), storage.PutOptions{
+		ContentType:  mediaType,
+		CacheControl: "public, max-age=31536000",
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create byte slice", nil)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload thumbnail to storage", err)
 		return
 	}
-	base64String := base64.RawURLEncoding.EncodeToString(mySlice)
-	filePath := filepath.Join(cfg.assetsRoot, base64String)
 
-	ext := ""
-	if mediaType == "image/png" {
-		ext = "png"
+	// Variants are nice to have; the thumbnail itself is already stored.
+	variants, err := cfg.thumbnailVariantsFromBytes(r.Context(), data, fileKey)
+	if err != nil {
+		log.Printf("Couldn't generate thumbnail variants for video %s: %v", video.ID, err)
 	}
-	filePath = fmt.Sprintf("%s.%s", filePath, ext)
-	destFile, err := os.Create(filePath)
+
+	thumbnailURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), fileKey)
+	err = cfg.db.SetCustomThumbnail(video.ID, thumbnailURL, variants)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create file", nil)
+		respondWithError(w, http.StatusInternalServerError, "couldn't update database with new thumbnail url", err)
 		return
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, file)
+	video, err = cfg.db.GetVideo(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't copy contents", nil)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
 		return
 	}
 
-	thumbnailURLpath := fmt.Sprintf("http://localhost:%s/%s", cfg.port, filePath)
-	video.ThumbnailURL = &thumbnailURLpath
-
-	err = cfg.db.UpdateVideo(video)
+	video, err = cfg.dbVideoToSignedVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't update database with new thumbnail url", err)
+		respondWithError(w, http.StatusInternalServerError, "couldn't sign video", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, video)
 }
+
+// thumbnailPrefix is where an uploaded thumbnail and its variants are
+// stored: with the video's other files once it has been uploaded, or under
+// thumbnails/ for a video that has no files yet.
+func thumbnailPrefix(video database.Video) string {
+	if video.VideoURL != nil {
+		if _, videoKey, ok := strings.Cut(*video.VideoURL, ","); ok {
+			return path.Join(videoPrefix(videoKey), "thumbnails")
+		}
+	}
+	return "thumbnails"
+}
+
+// thumbnailVariantsFromBytes stores resized variants of an uploaded
+// thumbnail stored at key.
+func (cfg *apiConfig) thumbnailVariantsFromBytes(ctx context.Context, data []byte, key string) (database.ThumbnailVariants, error) {
+	srcFile, err := os.CreateTemp("", "tubely-thumbnail-*"+path.Ext(key))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(srcFile.Name())
+	defer srcFile.Close()
+
+	_, err = srcFile.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = srcFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.storeThumbnailVariants(ctx, srcFile.Name(), key)
+}
@@ -2,51 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime"
 	"net/http"
 	"os"
-	"time"
+	"os/exec"
+	"path"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 1 << 30
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
-
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
-	}
-
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
+const videoUploadLimit = 1 << 30
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, videoUploadLimit)
 
-	// Get video metadata from database
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't get the video's metadata from the database", err)
-		return
-	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
 		return
 	}
 
@@ -63,141 +40,273 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", nil)
 		return
 	}
-	if mediaType != "video/mp4" {
+	if !uploadVideoTypes[mediaType] {
 		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
 		return
 	}
 
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	// Spool the upload somewhere that survives a restart; the processing job
+	// removes it when it's done.
+	spoolFile, err := os.CreateTemp(cfg.uploadsRoot, "upload-*")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	defer spoolFile.Close()
+
+	cfg.setVideoStatus(video.ID, database.VideoUploading, nil)
+	_, err = io.Copy(spoolFile, file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Invalid file type", nil)
+		os.Remove(spoolFile.Name())
+		cfg.setVideoStatus(video.ID, database.VideoFailed, fmt.Errorf("upload interrupted: %w", err))
+		respondWithError(w, http.StatusInternalServerError, "Couldn't copy contents", err)
 		return
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	_, err = io.Copy(tempFile, file)
+	err = spoolFile.Close()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't copy contents", nil)
+		os.Remove(spoolFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload", err)
 		return
 	}
 
-	_, err = tempFile.Seek(0, io.SeekStart)
+	// Trust what's in the file, not the Content-Type the client sent.
+	metadata, err := probeVideo(spoolFile.Name(), cfg.aspectBuckets)
+	if errors.Is(err, exec.ErrNotFound) {
+		os.Remove(spoolFile.Name())
+		cfg.setVideoStatus(video.ID, database.VideoFailed, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect upload", err)
+		return
+	}
+	if err == nil {
+		err = checkContainer(metadata.Container)
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't reset file pointer", nil)
+		os.Remove(spoolFile.Name())
+		cfg.setVideoStatus(video.ID, database.VideoFailed, err)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported video file", err)
 		return
 	}
 
-	fmt.Printf("Here's the name of the tempFile: %s\n", tempFile.Name())
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	job, err := cfg.enqueueVideoProcessing(video, processVideoPayload{
+		SourcePath: spoolFile.Name(),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to get aspect ratio", err)
+		os.Remove(spoolFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
 		return
 	}
 
-	fileKey := getAssetPath(mediaType)
-	fmt.Printf("fileKey: %s\n", fileKey)
+	respondWithJob(w, job)
+}
 
-	if aspectRatio == "16:9" {
-		fileKey = "landscape/horizontal.mp4"
-	} else if aspectRatio == "9:16" {
-		fileKey = "portrait/vertical.mp4"
-	} else {
-		fileKey = "other.mp4"
+// processVideoUpload runs an uploaded MP4 at filePath through the processing
+// pipeline: it records the video's metadata, remuxes it for fast start (or
+// transcodes it, if it isn't already an H.264/AAC MP4), stores
+// the result and points the video row at it, then generates a thumbnail if
+// the video doesn't have a custom one and seek-preview sprites. The video is
+// stored under assetName; if processing fails, whatever this attempt stored
+// is deleted again.
+func (cfg *apiConfig) processVideoUpload(ctx context.Context, video database.Video, filePath, assetName string) (_ database.Video, err error) {
+	cfg.events.publish(video.ID, videoEvent{Stage: stageProbing})
+	metadata, err := probeVideo(filePath, cfg.aspectBuckets)
+	if err != nil {
+		return database.Video{}, permanent(fmt.Errorf("couldn't probe video: %w", err))
 	}
-	fmt.Printf("fileKey: %s\n", fileKey)
-
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	err = checkContainer(metadata.Container)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to get aspect ratio", err)
-		return
+		return database.Video{}, permanent(err)
+	}
+	if metadata.VideoCodec == "" {
+		return database.Video{}, permanent(errors.New("upload has no video stream"))
+	}
+	metadata.VideoID = video.ID
+	err = cfg.db.SaveVideoMetadata(metadata)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't save video metadata: %w", err)
+	}
+
+	fileKey := getVideoKey(metadata.AspectRatio, assetName)
+	defer func() {
+		if err != nil {
+			cfg.deleteObjectTree(context.Background(), fileKey)
+		}
+	}()
+
+	var processedFilePath string
+	if isStreamableMP4(metadata) {
+		cfg.events.publish(video.ID, videoEvent{Stage: stageFastStart})
+		processedFilePath, err = processVideoForFastStart(ctx, filePath, func(percent float64) {
+			cfg.events.publish(video.ID, videoEvent{Stage: stageFastStart, Percent: percent})
+		})
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't process video for fast start: %w", err)
+		}
+	} else {
+		progress := func(percent float64) {
+			cfg.events.publish(video.ID, videoEvent{Stage: stageTranscoding, Percent: percent, Message: "mp4"})
+		}
+		progress(0)
+		processedFilePath, err = processVideoToMP4(ctx, filePath, progress)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't transcode video to mp4: %w", err)
+		}
 	}
 	defer os.Remove(processedFilePath)
 
 	processedFile, err := os.Open(processedFilePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to open processed file", err)
-		return
+		return database.Video{}, fmt.Errorf("couldn't open processed file: %w", err)
 	}
 	defer processedFile.Close()
 
-	// Read first 200 bytes to check for moov
-	buffer := make([]byte, 200)
-	_, err = processedFile.Read(buffer)
+	err = cfg.store.Put(ctx, fileKey, processedFile, storage.PutOptions{
+		ContentType:  "video/mp4",
+		CacheControl: "public, max-age=31536000",
+		Progress:     cfg.storeProgress(video.ID),
+	})
 	if err != nil {
-		fmt.Println("Error!")
+		return database.Video{}, fmt.Errorf("couldn't upload object to storage: %w", err)
 	}
-	fmt.Printf("First 200 bytes: %x\n", buffer)
+	log.Printf("Uploaded video %s to %s,%s", video.ID, cfg.store.Bucket(), fileKey)
 
-	_, err = processedFile.Seek(0, io.SeekStart)
+	vidURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), fileKey)
+	video.VideoURL = &vidURL
+	video.NeedsReupload = false
+	video.HLSURL = nil
+	video.DASHURL = nil
+
+	hlsKey, dashKey, err := cfg.processVideoStreams(ctx, video, filePath, videoPrefix(fileKey))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to reset file pointer", err)
-		return
+		return database.Video{}, err
+	}
+	if hlsKey != "" {
+		hlsURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), hlsKey)
+		video.HLSURL = &hlsURL
+	}
+	if dashKey != "" {
+		dashURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), dashKey)
+		video.DASHURL = &dashURL
 	}
 
-	// Generate 16-byte slice (32 characters in hex)
-	buf := make([]byte, 200)
-	_, err = processedFile.Read(buf)
+	err = cfg.db.SetVideoFiles(video.ID, vidURL, video.HLSURL, video.DASHURL)
 	if err != nil {
-		log.Printf("Error reading processed file: %v\n", err)
-	} else {
-		log.Printf("First 200 bytes of processed file before S3 upload: %x\n", buf)
+		return database.Video{}, fmt.Errorf("couldn't update video url: %w", err)
 	}
-	// Don't forget to seek back to start of file
-	processedFile.Seek(0, 0)
-
-	fmt.Printf("Here's the vid_url: %s,%s\n", cfg.s3Bucket, fileKey)
 
-	params := &s3.PutObjectInput{
-		Bucket:       aws.String(cfg.s3Bucket),
-		Key:          aws.String(fileKey),
-		Body:         processedFile,
-		ContentType:  aws.String("video/mp4"),
-		CacheControl: aws.String("public, max-age=31536000"),
+	// Reload so a custom thumbnail uploaded while the video was processing
+	// is seen.
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, permanent(errors.New("video deleted during processing"))
 	}
 
-	_, err = cfg.s3Client.PutObject(context.TODO(), params)
+	// Missing thumbnails or previews aren't worth failing the upload over.
+	withThumbnail, err := cfg.processVideoThumbnail(ctx, video, filePath, videoPrefix(fileKey))
 	if err != nil {
-		log.Printf("Failed to upload file: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload object to S3", nil)
-		return
+		log.Printf("Couldn't generate thumbnail for video %s: %v", video.ID, err)
+	} else {
+		video = withThumbnail
 	}
 
-	// Update the VideoURL of the video record in the database
-	vidURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, fileKey)
-	video.VideoURL = &vidURL
+	if cfg.previewInterval > 0 {
+		withPreviews, err := cfg.processVideoPreviews(ctx, video, filePath, videoPrefix(fileKey))
+		if err != nil {
+			log.Printf("Couldn't generate seek previews for video %s: %v", video.ID, err)
+		} else {
+			video = withPreviews
+		}
+	}
+	return video, nil
+}
 
-	fmt.Printf("Here's the updated URL: %s\n", vidURL)
-	err = cfg.db.UpdateVideo(video)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't update database with new thumbnail url", err)
-		return
+// processVideoStreams transcodes the video into an adaptive streaming
+// ladder in each enabled format, stores it under prefix and returns the keys
+// of the HLS and DASH manifests, or "" for a format that's turned off. The
+// ladder is encoded once: when both formats are on, the DASH muxer writes
+// HLS playlists for the same fMP4 segments.
+func (cfg *apiConfig) processVideoStreams(ctx context.Context, video database.Video, filePath, prefix string) (string, string, error) {
+	withHLS := cfg.streamingFormats[formatHLS]
+	withDASH := cfg.streamingFormats[formatDASH]
+	if !withHLS && !withDASH {
+		return "", "", nil
 	}
 
-	video, err = cfg.dbVideoToSignedVideo(video)
+	src, err := probeSource(filePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't sign video", err)
-		return
+		return "", "", fmt.Errorf("couldn't probe source: %w", err)
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
-}
+	dir := formatHLS
+	switch {
+	case withHLS && withDASH:
+		dir = sharedStreamDir
+	case withDASH:
+		dir = formatDASH
+	}
 
-func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-	// Create a presigning client
-	presignClient := s3.NewPresignClient(s3Client)
+	outDir, err := os.MkdirTemp("", "tubely-"+dir+"-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(outDir)
+
+	progress := func(percent float64) {
+		cfg.events.publish(video.ID, videoEvent{Stage: stageTranscoding, Percent: percent, Message: dir})
+	}
+	progress(0)
 
-	// Set up input parameters
-	params := &s3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
+	if withDASH {
+		err = transcodeDASH(ctx, filePath, outDir, src, withHLS, progress)
+	} else {
+		err = transcodeHLS(ctx, filePath, outDir, src, progress)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't transcode to %s: %w", dir, err)
 	}
 
-	// Generate the presigned URL
-	req, err := presignClient.PresignGetObject(context.TODO(), params, s3.WithPresignExpires(expireTime))
+	streamPrefix := path.Join(prefix, dir)
+	cfg.events.publish(video.ID, videoEvent{Stage: stageStoring, Message: "uploading " + dir + " segments"})
+	err = cfg.uploadDir(ctx, outDir, streamPrefix)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	var hlsKey, dashKey string
+	if withHLS {
+		hlsKey = path.Join(streamPrefix, "master.m3u8")
+	}
+	if withDASH {
+		dashKey = path.Join(streamPrefix, "manifest.mpd")
 	}
+	return hlsKey, dashKey, nil
+}
 
-	return req.URL, nil
+// storeProgress returns a storage progress callback that publishes a
+// storing event, and logs, each time another percent of the video has been
+// stored.
+func (cfg *apiConfig) storeProgress(videoID uuid.UUID) storage.ProgressFunc {
+	var lastPercent int64 = -1
+	return func(uploaded, total int64) {
+		if total <= 0 {
+			return
+		}
+		percent := uploaded * 100 / total
+		if percent == lastPercent {
+			return
+		}
+		lastPercent = percent
+		if percent%10 == 0 {
+			log.Printf("Uploading video %s: %d/%d bytes (%d%%)", videoID, uploaded, total, percent)
+		}
+		cfg.events.publish(videoID, videoEvent{
+			Stage:   stageStoring,
+			Percent: float64(percent),
+			Bytes:   uploaded,
+			Total:   total,
+		})
+	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const sseHeartbeat = 15 * time.Second
+
+// handlerVideoEvents streams processing progress for a video as Server-Sent
+// Events. The first event reflects the video's current status; the stream
+// ends after a ready or failed event.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming isn't supported", nil)
+		return
+	}
+
+	// Subscribe before reading the current status so no event is missed in
+	// between.
+	events, unsubscribe := cfg.events.subscribe(video.ID)
+	defer unsubscribe()
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	current := videoEvent{Stage: string(video.Status)}
+	if video.StatusError != nil {
+		current.Message = *video.StatusError
+	}
+	if video.Status == database.VideoReady {
+		current.Percent = 100
+	}
+	err = writeSSE(w, current)
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+	if current.Stage == stageReady || current.Stage == stageFailed {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-events:
+			err = writeSSE(w, event)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			if event.Stage == stageReady || event.Stage == stageFailed {
+				return
+			}
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event videoEvent) error {
+	dat, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Stage, dat)
+	return err
+}
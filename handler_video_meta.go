@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
@@ -18,20 +20,11 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
+	userID := principalFrom(r).UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
@@ -48,41 +41,91 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	err := cfg.deleteVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteVideo removes a video and everything kept for it: queued processing
+// jobs and their sources, unfinished resumable uploads, and, in the
+// background, its objects in the store. A job already running notices the
+// video is gone when it finishes and removes what it stored.
+func (cfg *apiConfig) deleteVideo(video database.Video) error {
+	jobs, err := cfg.db.CancelVideoJobs(video.ID, "video deleted")
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
+		return fmt.Errorf("couldn't cancel jobs: %w", err)
+	}
+	uploads, err := cfg.db.GetVideoUploads(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get uploads: %w", err)
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	err = cfg.db.DeleteVideo(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
-		return
+		return err
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
-		return
+
+	for _, upload := range uploads {
+		cfg.removeUpload(upload)
+	}
+	go func() {
+		ctx := context.Background()
+		for _, job := range jobs {
+			cfg.cleanupProcessVideoSource(ctx, job)
+		}
+		cfg.purgeVideoObjects(ctx, video)
+	}()
+	return nil
+}
+
+// purgeVideoObjects deletes the video's MP4 and everything stored under its
+// prefix, a custom thumbnail uploaded before the video and its variants, and
+// any staged direct upload.
+func (cfg *apiConfig) purgeVideoObjects(ctx context.Context, video database.Video) {
+	for _, stored := range []*string{video.VideoURL, video.ThumbnailURL, video.ThumbnailWebPURL} {
+		if stored == nil {
+			continue
+		}
+		bucket, key, ok := strings.Cut(*stored, ",")
+		if !ok || bucket != cfg.store.Bucket() {
+			continue
+		}
+		cfg.deleteObjectTree(ctx, key)
+	}
+	cfg.deletePrefix(ctx, stagingPrefix(video.ID))
+}
+
+// deleteObjectTree deletes the object at key and everything derived from it
+// under videoPrefix(key).
+func (cfg *apiConfig) deleteObjectTree(ctx context.Context, key string) {
+	err := cfg.store.Delete(ctx, key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Couldn't delete %s: %v", key, err)
 	}
+	cfg.deletePrefix(ctx, videoPrefix(key)+"/")
+}
 
-	err = cfg.db.DeleteVideo(videoID)
+func (cfg *apiConfig) deletePrefix(ctx context.Context, prefix string) {
+	objects, err := cfg.store.List(ctx, prefix)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		log.Printf("Couldn't list %s: %v", prefix, err)
 		return
 	}
-
-	w.WriteHeader(http.StatusNoContent)
+	for _, obj := range objects {
+		err = cfg.store.Delete(ctx, obj.Key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Couldn't delete %s: %v", obj.Key, err)
+		}
+	}
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
@@ -95,11 +138,35 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
 		return
 	}
+	if video.UnpublishedAt == nil {
+		cfg.respondWithVideo(w, video)
+		return
+	}
+
+	// Unpublished videos are only visible to their owner, who has to
+	// authenticate to see them.
+	if r.Header.Get("Authorization") == "" {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	cfg.requireAuth(database.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if principalFrom(r).UserID != video.UserID {
+			respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+			return
+		}
+		cfg.respondWithVideo(w, video)
+	})(w, r)
+}
 
-	video, err = cfg.dbVideoToSignedVideo(video)
+func (cfg *apiConfig) respondWithVideo(w http.ResponseWriter, video database.Video) {
+	video, err := cfg.dbVideoToSignedVideo(video)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "couldn't sign video", err)
 		return
@@ -109,18 +176,21 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
+	userID := principalFrom(r).UserID
+
+	var statuses []database.VideoStatus
+	if param := r.URL.Query().Get("status"); param != "" {
+		for _, s := range strings.Split(param, ",") {
+			status := database.VideoStatus(strings.TrimSpace(s))
+			if !status.Valid() {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid status %q", s), nil)
+				return
+			}
+			statuses = append(statuses, status)
+		}
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	videos, err := cfg.db.GetVideos(userID, statuses...)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
@@ -138,20 +208,104 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 }
 
 func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-	if video.VideoURL == nil {
-		return database.Video{}, errors.New("nil video url")
+	metadata, err := cfg.db.GetVideoMetadata(video.ID)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't get video metadata: %w", err)
+	}
+	video.Metadata = metadata
+
+	// Unpublished videos are returned without any media, to their owner
+	// included. URLs signed before the video was unpublished keep working
+	// until they expire.
+	if video.UnpublishedAt != nil {
+		video.VideoURL = nil
+		video.HLSURL = nil
+		video.DASHURL = nil
+		video.PreviewsURL = nil
+		video.ThumbnailURL = nil
+		video.ThumbnailWebPURL = nil
+		return video, nil
+	}
+
+	if video.VideoURL != nil {
+		url, err := cfg.signStoredURL(*video.VideoURL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign video url: %w", err)
+		}
+		video.VideoURL = &url
+	}
+
+	if video.HLSURL != nil {
+		url, err := cfg.streamURL(video.ID, *video.HLSURL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign hls url: %w", err)
+		}
+		video.HLSURL = &url
+	}
+
+	if video.DASHURL != nil {
+		url, err := cfg.streamURL(video.ID, *video.DASHURL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign dash url: %w", err)
+		}
+		video.DASHURL = &url
+	}
+
+	if video.PreviewsURL != nil {
+		url, err := cfg.streamURL(video.ID, *video.PreviewsURL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign previews url: %w", err)
+		}
+		video.PreviewsURL = &url
+	}
+
+	if video.ThumbnailURL != nil {
+		url, err := cfg.signStoredURL(*video.ThumbnailURL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign thumbnail url: %w", err)
+		}
+		video.ThumbnailURL = &url
+	}
+
+	video.ThumbnailSrcset, err = cfg.thumbnailSrcset(video.ThumbnailVariants)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't sign thumbnail variants: %w", err)
 	}
-	strList := strings.Split(*video.VideoURL, ",")
+
+	if video.ThumbnailWebPURL != nil {
+		url, err := cfg.signStoredURL(*video.ThumbnailWebPURL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign thumbnail url: %w", err)
+		}
+		video.ThumbnailWebPURL = &url
+	}
+
+	return video, nil
+}
+
+// signStoredURL turns a "bucket,key" value stored on a video row into a URL
+// clients can fetch: a CloudFront URL in cloudfront delivery mode, otherwise
+// a presigned storage URL. Values that are already URLs (thumbnails saved before
+// they moved into object storage) are returned unchanged.
+func (cfg *apiConfig) signStoredURL(stored string) (string, error) {
+	if strings.HasPrefix(stored, "http://") || strings.HasPrefix(stored, "https://") {
+		return stored, nil
+	}
+	strList := strings.Split(stored, ",")
 	if len(strList) != 2 {
-		return database.Video{}, errors.New("invalid video url")
+		return "", errors.New("invalid stored url")
 	}
 	bucket := strList[0]
 	key := strList[1]
-	url, err := generatePresignedURL(cfg.s3Client, bucket, key, time.Hour)
+	if bucket != cfg.store.Bucket() {
+		return "", fmt.Errorf("object stored in unknown bucket %q", bucket)
+	}
+	if cfg.cdn != nil {
+		return cfg.cdn.cdnURL(key)
+	}
+	url, err := cfg.store.PresignGet(context.TODO(), key, time.Hour)
 	if err != nil {
-		return database.Video{}, fmt.Errorf("couldn't presign url: %w", err)
+		return "", fmt.Errorf("couldn't presign url: %w", err)
 	}
-
-	video.VideoURL = &url
-	return video, nil
+	return url, nil
 }
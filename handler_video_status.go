@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoStatus(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID                  uuid.UUID            `json:"id"`
+		Status              database.VideoStatus `json:"status"`
+		Error               *string              `json:"error"`
+		UpdatedAt           *time.Time           `json:"updated_at"`
+		ProcessingStartedAt *time.Time           `json:"processing_started_at"`
+		ProcessedAt         *time.Time           `json:"processed_at"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		ID:                  video.ID,
+		Status:              video.Status,
+		Error:               video.StatusError,
+		UpdatedAt:           video.StatusUpdatedAt,
+		ProcessingStartedAt: video.ProcessingStartedAt,
+		ProcessedAt:         video.ProcessedAt,
+	})
+}
+
+// setVideoStatus records a status change, logging rather than failing the
+// caller if it can't be saved.
+func (cfg *apiConfig) setVideoStatus(videoID uuid.UUID, status database.VideoStatus, cause error) {
+	var errMsg *string
+	if cause != nil {
+		msg := cause.Error()
+		errMsg = &msg
+	}
+	err := cfg.db.SetVideoStatus(videoID, status, errMsg)
+	if err != nil {
+		log.Printf("Couldn't set video %s status to %s: %v", videoID, status, err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// streamURLTTL is how long a stream URL, and every segment URL signed from
+// it, stays valid. Players fetch a VOD manifest once, so it has to cover a
+// whole viewing.
+const streamURLTTL = 4 * time.Hour
+
+// streamURL returns the API URL that serves the manifest or preview track
+// stored at stored (a "bucket,key" value) for the video. The token sits in the path so that
+// relative references inside the manifests resolve back through it.
+func (cfg *apiConfig) streamURL(videoID uuid.UUID, stored string) (string, error) {
+	_, key, ok := strings.Cut(stored, ",")
+	if !ok {
+		return "", errors.New("invalid stored url")
+	}
+	format := path.Base(path.Dir(key))
+	token := auth.MakeStreamToken(videoID.String(), cfg.jwtSecret, time.Now().Add(streamURLTTL))
+	return fmt.Sprintf("/api/videos/%s/stream/%s/%s/%s", videoID, token, format, path.Base(key)), nil
+}
+
+// handlerVideoStream serves a video's HLS, DASH and seek-preview files to
+// players holding a stream token. HLS playlists and preview tracks are
+// rewritten so every URI in them is signed; anything else, including DASH
+// segments named by templates, is redirected to a signed URL for the object.
+// Unpublished videos can't be streamed, even with a token signed before an
+// admin unpublished them; segment URLs already handed out still work until
+// they expire.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	err = auth.ValidateStreamToken(r.PathValue("token"), videoID.String(), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate stream token", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.UnpublishedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+
+	// The first path element is the directory of one of the video's
+	// manifests; HLS and DASH share one when they're packaged together.
+	relPath := path.Clean(r.PathValue("path"))
+	dir, _, _ := strings.Cut(relPath, "/")
+	var stored *string
+	for _, u := range []*string{video.HLSURL, video.DASHURL, video.PreviewsURL} {
+		if u == nil {
+			continue
+		}
+		if _, key, _ := strings.Cut(*u, ","); path.Base(path.Dir(key)) == dir {
+			stored = u
+			break
+		}
+	}
+	if stored == nil || strings.HasPrefix(relPath, "..") {
+		respondWithError(w, http.StatusNotFound, "Couldn't find stream", nil)
+		return
+	}
+	_, manifestKey, _ := strings.Cut(*stored, ",")
+	prefix := path.Dir(path.Dir(manifestKey))
+	key := path.Join(prefix, relPath)
+
+	signer, err := cfg.prefixSigner(r.Context(), prefix)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign stream", err)
+		return
+	}
+
+	switch path.Ext(key) {
+	case ".m3u8", ".mpd", ".vtt":
+	default:
+		signedURL, err := signer(key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign segment", err)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, signedURL, http.StatusFound)
+		return
+	}
+
+	body, _, err := cfg.store.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find stream", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get manifest", err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", streamingContentType(key))
+	w.Header().Set("Cache-Control", "private, no-store")
+	var signed string
+	switch path.Ext(key) {
+	case ".mpd":
+		io.Copy(w, body)
+		return
+	case ".vtt":
+		signed, err = signPreviewTrack(body, path.Dir(key), signer)
+	default:
+		signed, err = signPlaylist(body, path.Dir(key), signer)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign "+path.Base(key), err)
+		return
+	}
+	w.Write([]byte(signed))
+}
+
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// signPlaylist rewrites the media URIs in an HLS playlist stored under dir to
+// signed URLs. References to other playlists are left relative so they come
+// back through the stream handler.
+func signPlaylist(body io.Reader, dir string, signer func(key string) (string, error)) (string, error) {
+	var errSign error
+	resolve := func(ref string) string {
+		if ref == "" || strings.Contains(ref, "://") || path.Ext(ref) == ".m3u8" {
+			return ref
+		}
+		signed, err := signer(path.Join(dir, ref))
+		if err != nil {
+			errSign = err
+			return ref
+		}
+		return signed
+	}
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+			line = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				ref := playlistURIAttr.FindStringSubmatch(attr)[1]
+				return `URI="` + resolve(ref) + `"`
+			})
+		case strings.TrimSpace(line) != "":
+			line = resolve(strings.TrimSpace(line))
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if errSign != nil {
+		return "", errSign
+	}
+	return b.String(), nil
+}
+
+// signPreviewTrack rewrites the sprite sheet references in a seek-preview
+// WebVTT track stored under dir to signed URLs, keeping their #xywh
+// fragments.
+func signPreviewTrack(body io.Reader, dir string, signer func(key string) (string, error)) (string, error) {
+	var b strings.Builder
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if ref, fragment, ok := strings.Cut(line, "#xywh="); ok && !strings.Contains(ref, "://") {
+			signed, err := signer(path.Join(dir, ref))
+			if err != nil {
+				return "", err
+			}
+			line = signed + "#xywh=" + fragment
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// prefixSigner returns a function that signs URLs for keys under prefix. With
+// a CloudFront key pair a single wildcard policy covers the whole prefix, so
+// each segment only needs the shared query string appended; otherwise every
+// key is presigned on its own.
+func (cfg *apiConfig) prefixSigner(ctx context.Context, prefix string) (func(key string) (string, error), error) {
+	if cfg.cdn == nil {
+		return func(key string) (string, error) {
+			return cfg.store.PresignGet(ctx, key, streamURLTTL)
+		}, nil
+	}
+	if cfg.cdn.signer == nil {
+		return cfg.cdn.cdnURL, nil
+	}
+
+	now := time.Now().UTC()
+	resource := (&url.URL{Scheme: "https", Host: cfg.cdn.domain, Path: "/" + prefix + "/*"}).String()
+	encodedPolicy, signature, err := cfg.cdn.signer.SignPolicy(cdn.Policy{
+		Resource:        resource,
+		DateGreaterThan: now.Add(-5 * time.Minute),
+		DateLessThan:    now.Add(streamURLTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("Policy", encodedPolicy)
+	query.Set("Signature", signature)
+	query.Set("Key-Pair-Id", cfg.cdn.signer.KeyPairID())
+	return func(key string) (string, error) {
+		u := url.URL{
+			Scheme:   "https",
+			Host:     cfg.cdn.domain,
+			Path:     "/" + key,
+			RawQuery: query.Encode(),
+		}
+		return u.String(), nil
+	}, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	thumbnailMaxBytes     = 10 << 20
+	thumbnailMaxDimension = 4096
+	thumbnailMinDimension = 16
+	thumbnailJPEGQuality  = 90
+)
+
+var errInvalidImage = errors.New("invalid image")
+
+// normalizeImage checks that body really is the JPEG or PNG declared by
+// mediaType and within our size limits, then re-encodes it. Re-encoding drops
+// EXIF and any other metadata; a JPEG's EXIF orientation is applied to the
+// pixels first so the image still displays the right way up. It returns the
+// encoded image and its media type.
+func normalizeImage(body io.Reader, mediaType string) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(body, thumbnailMaxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > thumbnailMaxBytes {
+		return nil, "", fmt.Errorf("%w: larger than %d bytes", errInvalidImage, thumbnailMaxBytes)
+	}
+
+	sniffed := http.DetectContentType(data)
+	if sniffed != mediaType {
+		return nil, "", fmt.Errorf("%w: content is %s, not %s", errInvalidImage, sniffed, mediaType)
+	}
+
+	// Check the dimensions before decoding so a small file can't claim a
+	// huge canvas.
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if config.Width > thumbnailMaxDimension || config.Height > thumbnailMaxDimension {
+		return nil, "", fmt.Errorf("%w: %dx%d is larger than %dx%d", errInvalidImage, config.Width, config.Height, thumbnailMaxDimension, thumbnailMaxDimension)
+	}
+	if config.Width < thumbnailMinDimension || config.Height < thumbnailMinDimension {
+		return nil, "", fmt.Errorf("%w: %dx%d is smaller than %dx%d", errInvalidImage, config.Width, config.Height, thumbnailMinDimension, thumbnailMinDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		img = applyOrientation(img, jpegOrientation(data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+		mediaType = "image/jpeg"
+	case "png":
+		err = png.Encode(&buf, img)
+		mediaType = "image/png"
+	default:
+		return nil, "", fmt.Errorf("%w: unsupported format %s", errInvalidImage, format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mediaType, nil
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) stored in a JPEG's APP1
+// segment, or 1 if there isn't one.
+func jpegOrientation(data []byte) int {
+	r := bytes.NewReader(data)
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		// Start of scan: the metadata segments are all behind us.
+		if marker[1] == 0xDA {
+			return 1
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return 1
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF-structured
+// EXIF block.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation turns and flips img so it displays upright without its
+// EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5-8 swap width and height.
+	outW, outH := w, h
+	if orientation >= 5 {
+		outW, outH = h, w
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.SetNRGBA(dx, dy, src.NRGBAAt(x, y))
+		}
+	}
+	return dst
+}
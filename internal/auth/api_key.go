@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const apiKeyPrefix = "tbly_"
+
+// MakeAPIKey returns a new random API key and the short prefix of it that is
+// safe to store and show back to its owner.
+func MakeAPIKey() (key, prefix string, err error) {
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(secret)
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+// HashAPIKey returns the digest an API key is stored and looked up by. Keys
+// are random rather than chosen by people, so a fast unsalted hash is
+// enough and lets us find a key without knowing whose it is.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
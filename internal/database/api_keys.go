@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyScope string
+
+const (
+	ScopeRead   APIKeyScope = "read"
+	ScopeUpload APIKeyScope = "upload"
+	ScopeDelete APIKeyScope = "delete"
+)
+
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case ScopeRead, ScopeUpload, ScopeDelete:
+		return true
+	}
+	return false
+}
+
+// APIKey is a long-lived credential for scripts and CI. Only a hash of the
+// key is stored; Prefix is kept so users can tell their keys apart.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID    uuid.UUID     `json:"user_id"`
+	Name      string        `json:"name"`
+	Prefix    string        `json:"prefix"`
+	KeyHash   string        `json:"-"`
+	Scopes    []APIKeyScope `json:"scopes"`
+	ExpiresAt *time.Time    `json:"expires_at"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k APIKey) HasScope(scope APIKeyScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		last_used_at,
+		revoked_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at
+`
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	scopes := make([]string, len(params.Scopes))
+	for i, s := range params.Scopes {
+		scopes[i] = string(s)
+	}
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		t := dbTime(*params.ExpiresAt)
+		expiresAt = &t
+	}
+	_, err := c.db.Exec(
+		query,
+		id.String(),
+		params.UserID.String(),
+		params.Name,
+		params.Prefix,
+		params.KeyHash,
+		strings.Join(scopes, ","),
+		expiresAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `FROM api_keys WHERE id = ?`
+	key, err := scanAPIKey(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash looks up a key by the hash of the secret the client sent.
+// It returns a zero APIKey if there is no such key.
+func (c Client) GetAPIKeyByHash(hash string) (APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `FROM api_keys WHERE key_hash = ?`
+	key, err := scanAPIKey(c.db.QueryRow(query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetAPIKeys returns all of the user's keys, including revoked and expired
+// ones, newest first.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `FROM api_keys WHERE user_id = ? ORDER BY created_at DESC`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of the user's keys. It reports false if the user
+// has no such key or it was already revoked.
+func (c Client) RevokeAPIKey(userID, id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	res, err := c.db.Exec(query, id.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeAPIKeys revokes all of the user's keys.
+func (c Client) RevokeAPIKeys(userID uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var id, userID, scopes string
+	err := row.Scan(
+		&id,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&userID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.ExpiresAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.ID, err = uuid.Parse(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = []APIKeyScope{}
+	for _, s := range strings.Split(scopes, ",") {
+		if s != "" {
+			key.Scopes = append(key.Scopes, APIKeyScope(s))
+		}
+	}
+	return key, nil
+}
@@ -3,7 +3,9 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -39,6 +41,14 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	_, err = c.addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'creator'")
+	if err != nil {
+		return err
+	}
+	_, err = c.addColumnIfMissing("users", "disabled_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
 	refreshTokenTable := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
 		token TEXT PRIMARY KEY,
@@ -54,6 +64,27 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	_, err = c.addColumnIfMissing("refresh_tokens", "family_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.backfillFamilyIDs()
+	if err != nil {
+		return err
+	}
+	refreshTokenColumns := []string{
+		"replaced_by TEXT",
+		"last_used_at TIMESTAMP",
+		"user_agent TEXT",
+		"ip_address TEXT",
+	}
+	for _, column := range refreshTokenColumns {
+		name, definition, _ := strings.Cut(column, " ")
+		_, err = c.addColumnIfMissing("refresh_tokens", name, definition)
+		if err != nil {
+			return err
+		}
+	}
 
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
@@ -72,10 +103,239 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	_, err = c.addColumnIfMissing("videos", "needs_reupload", "BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+	added, err := c.addColumnIfMissing("videos", "status", "TEXT NOT NULL DEFAULT 'draft'")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Videos uploaded before statuses existed are already processed.
+		_, err = c.db.Exec("UPDATE videos SET status = 'ready' WHERE video_url IS NOT NULL")
+		if err != nil {
+			return err
+		}
+	}
+	videoColumns := []string{
+		"status_error TEXT",
+		"status_updated_at TIMESTAMP",
+		"processing_started_at TIMESTAMP",
+		"processed_at TIMESTAMP",
+		"hls_url TEXT",
+		"dash_url TEXT",
+		"thumbnail_webp_url TEXT",
+		"thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE",
+		"thumbnail_variants TEXT",
+		"previews_url TEXT",
+		"unpublished_at TIMESTAMP",
+	}
+	for _, column := range videoColumns {
+		name, definition, _ := strings.Cut(column, " ")
+		_, err = c.addColumnIfMissing("videos", name, definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	uploadTable := `
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		length INTEGER NOT NULL,
+		upload_offset INTEGER NOT NULL DEFAULT 0,
+		metadata TEXT NOT NULL DEFAULT '',
+		file_path TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(uploadTable)
+	if err != nil {
+		return err
+	}
+	added, err = c.addColumnIfMissing("uploads", "expires_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Give uploads started before expiry was tracked a day to finish.
+		_, err = c.db.Exec("UPDATE uploads SET expires_at = datetime(updated_at, '+1 day') WHERE expires_at IS NULL")
+		if err != nil {
+			return err
+		}
+	}
+
+	metadataTable := `
+	CREATE TABLE IF NOT EXISTS video_metadata (
+		video_id TEXT PRIMARY KEY,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		duration REAL NOT NULL DEFAULT 0,
+		width INTEGER NOT NULL DEFAULT 0,
+		height INTEGER NOT NULL DEFAULT 0,
+		rotation INTEGER NOT NULL DEFAULT 0,
+		video_codec TEXT NOT NULL DEFAULT '',
+		audio_codec TEXT NOT NULL DEFAULT '',
+		bit_rate INTEGER NOT NULL DEFAULT 0,
+		frame_rate REAL NOT NULL DEFAULT 0,
+		audio_channels INTEGER NOT NULL DEFAULT 0,
+		container TEXT NOT NULL DEFAULT '',
+		aspect_ratio TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(metadataTable)
+	if err != nil {
+		return err
+	}
+	_, err = c.addColumnIfMissing("video_metadata", "major_brand", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		type TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		payload TEXT NOT NULL DEFAULT '',
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		last_error TEXT,
+		run_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS jobs_state_run_at ON jobs(state, run_at);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// backfillFamilyIDs gives each refresh token issued before rotation its own
+// family. The family ID is shown to users as their session ID, so it must
+// not be the token itself; older versions of this migration did that, and
+// those families are given a fresh ID too.
+func (c *Client) backfillFamilyIDs() error {
+	rows, err := c.db.Query(`
+		SELECT DISTINCT COALESCE(family_id, token)
+		FROM refresh_tokens
+		WHERE family_id IS NULL OR family_id IN (SELECT token FROM refresh_tokens)
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var families []string
+	for rows.Next() {
+		var family string
+		if err := rows.Scan(&family); err != nil {
+			return err
+		}
+		families = append(families, family)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, family := range families {
+		_, err = c.db.Exec(
+			"UPDATE refresh_tokens SET family_id = ? WHERE family_id = ? OR (family_id IS NULL AND token = ?)",
+			uuid.NewString(),
+			family,
+			family,
+		)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// addColumnIfMissing adds a column to a table created by an older version of
+// the schema, since CREATE TABLE IF NOT EXISTS leaves existing tables alone.
+// It reports whether the column had to be added.
+func (c *Client) addColumnIfMissing(table, column, definition string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_metadata"); err != nil {
+		return fmt.Errorf("failed to reset table video_metadata: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM uploads"); err != nil {
+		return fmt.Errorf("failed to reset table uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
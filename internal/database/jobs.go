@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobState string
+
+const (
+	JobQueued     JobState = "queued"
+	JobProcessing JobState = "processing"
+	JobDone       JobState = "done"
+	JobFailed     JobState = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	State     JobState  `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"last_error"`
+	RunAt     time.Time `json:"run_at"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Type        string    `json:"type"`
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Payload     string    `json:"-"`
+	MaxAttempts int       `json:"max_attempts"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		type,
+		video_id,
+		user_id,
+		payload,
+		state,
+		attempts,
+		max_attempts,
+		last_error,
+		run_at
+`
+
+// dbTime formats times the same way for every comparison against run_at.
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		video_id,
+		user_id,
+		payload,
+		state,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id.String(),
+		params.Type,
+		params.VideoID.String(),
+		params.UserID.String(),
+		params.Payload,
+		JobQueued,
+		params.MaxAttempts,
+		dbTime(time.Now()),
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `SELECT` + jobColumns + `FROM jobs WHERE id = ?`
+	job, err := scanJob(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimJob moves the oldest queued job that is due to processing and
+// returns it. It returns a zero Job if there is nothing to do.
+func (c Client) ClaimJob() (Job, error) {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		attempts = attempts + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE state = ? AND run_at <= ?
+		ORDER BY run_at, created_at
+		LIMIT 1
+	)
+	RETURNING` + jobColumns
+	job, err := scanJob(c.db.QueryRow(query, JobProcessing, JobQueued, dbTime(time.Now())))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET state = ?, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobDone, id.String())
+	return err
+}
+
+// RetryJob puts a job back in the queue to run again at runAt.
+func (c Client) RetryJob(id uuid.UUID, errMsg string, runAt time.Time) error {
+	query := `
+	UPDATE jobs
+	SET state = ?, last_error = ?, run_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobQueued, errMsg, dbTime(runAt), id.String())
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, errMsg string) error {
+	query := `
+	UPDATE jobs
+	SET state = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobFailed, errMsg, id.String())
+	return err
+}
+
+// RequeueProcessingJobs returns jobs left in processing by a server that
+// stopped mid-job to the queue. It returns how many were requeued.
+func (c Client) RequeueProcessingJobs() (int64, error) {
+	query := `
+	UPDATE jobs
+	SET state = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE state = ?
+	`
+	res, err := c.db.Exec(query, JobQueued, JobProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CancelVideoJobs fails the video's jobs that haven't started yet and
+// returns them, so callers can clean up what they were going to process.
+func (c Client) CancelVideoJobs(videoID uuid.UUID, reason string) ([]Job, error) {
+	query := `
+	UPDATE jobs
+	SET state = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE video_id = ? AND state = ?
+	RETURNING` + jobColumns
+	rows, err := c.db.Query(query, JobFailed, reason, videoID.String(), JobQueued)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	var id, videoID, userID string
+	err := row.Scan(
+		&id,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&videoID,
+		&userID,
+		&job.Payload,
+		&job.State,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+	job.ID, err = uuid.Parse(id)
+	if err != nil {
+		return Job{}, err
+	}
+	job.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return Job{}, err
+	}
+	job.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
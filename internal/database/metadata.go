@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoMetadata is what ffprobe reported about a video's processed source.
+type VideoMetadata struct {
+	VideoID       uuid.UUID `json:"-"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Duration      float64   `json:"duration"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	Rotation      int       `json:"rotation"`
+	VideoCodec    string    `json:"video_codec"`
+	AudioCodec    string    `json:"audio_codec"`
+	BitRate       int64     `json:"bit_rate"`
+	FrameRate     float64   `json:"frame_rate"`
+	AudioChannels int       `json:"audio_channels"`
+	Container     string    `json:"container"`
+	MajorBrand    string    `json:"major_brand"`
+	AspectRatio   string    `json:"aspect_ratio"`
+}
+
+// SaveVideoMetadata stores metadata for its video, replacing whatever an
+// earlier upload recorded.
+func (c Client) SaveVideoMetadata(metadata VideoMetadata) error {
+	query := `
+	INSERT INTO video_metadata (
+		video_id,
+		updated_at,
+		duration,
+		width,
+		height,
+		rotation,
+		video_codec,
+		audio_codec,
+		bit_rate,
+		frame_rate,
+		audio_channels,
+		container,
+		major_brand,
+		aspect_ratio
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		duration = excluded.duration,
+		width = excluded.width,
+		height = excluded.height,
+		rotation = excluded.rotation,
+		video_codec = excluded.video_codec,
+		audio_codec = excluded.audio_codec,
+		bit_rate = excluded.bit_rate,
+		frame_rate = excluded.frame_rate,
+		audio_channels = excluded.audio_channels,
+		container = excluded.container,
+		major_brand = excluded.major_brand,
+		aspect_ratio = excluded.aspect_ratio
+	`
+	_, err := c.db.Exec(
+		query,
+		metadata.VideoID,
+		metadata.Duration,
+		metadata.Width,
+		metadata.Height,
+		metadata.Rotation,
+		metadata.VideoCodec,
+		metadata.AudioCodec,
+		metadata.BitRate,
+		metadata.FrameRate,
+		metadata.AudioChannels,
+		metadata.Container,
+		metadata.MajorBrand,
+		metadata.AspectRatio,
+	)
+	return err
+}
+
+// GetVideoMetadata returns the metadata recorded for a video, or nil if it
+// hasn't been processed yet.
+func (c Client) GetVideoMetadata(videoID uuid.UUID) (*VideoMetadata, error) {
+	query := `
+	SELECT
+		video_id,
+		updated_at,
+		duration,
+		width,
+		height,
+		rotation,
+		video_codec,
+		audio_codec,
+		bit_rate,
+		frame_rate,
+		audio_channels,
+		container,
+		major_brand,
+		aspect_ratio
+	FROM video_metadata
+	WHERE video_id = ?
+	`
+	var metadata VideoMetadata
+	err := c.db.QueryRow(query, videoID).Scan(
+		&metadata.VideoID,
+		&metadata.UpdatedAt,
+		&metadata.Duration,
+		&metadata.Width,
+		&metadata.Height,
+		&metadata.Rotation,
+		&metadata.VideoCodec,
+		&metadata.AudioCodec,
+		&metadata.BitRate,
+		&metadata.FrameRate,
+		&metadata.AudioChannels,
+		&metadata.Container,
+		&metadata.MajorBrand,
+		&metadata.AspectRatio,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &metadata, nil
+}
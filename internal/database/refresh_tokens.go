@@ -7,17 +7,37 @@ import (
 	"github.com/google/uuid"
 )
 
+// RefreshToken is one link in a chain of rotated refresh tokens. Tokens
+// rotated from the same login share a FamilyID; ReplacedBy is set once a
+// token has been exchanged for its successor.
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *string    `json:"-"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
+	FamilyID  string    `json:"family_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+}
+
+// Session is a login on one device: the live token of a refresh token
+// family.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	Current    bool       `json:"current"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
@@ -27,10 +47,13 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			family_id,
+			expires_at,
+			user_agent,
+			ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.FamilyID, params.ExpiresAt, params.UserAgent, params.IPAddress)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -38,6 +61,48 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes old and issues next in its place, in the same
+// family, marking the session as used now. It reports false, and changes
+// nothing, if old was already revoked or rotated by the time the update ran.
+func (c Client) RotateRefreshToken(old string, next CreateRefreshTokenParams) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token = ? AND revoked_at IS NULL
+	`, next.Token, old)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			last_used_at,
+			user_id,
+			family_id,
+			expires_at,
+			user_agent,
+			ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`, next.Token, next.UserID.String(), next.FamilyID, next.ExpiresAt, next.UserAgent, next.IPAddress)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
@@ -48,16 +113,140 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+// RevokeRefreshTokenFamily revokes every token rotated from the same login.
+func (c Client) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, familyID)
+	return err
+}
+
+// GetSessions returns the user's sessions that can still be refreshed, most
+// recently used first.
+func (c Client) GetSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT
+			rt.family_id,
+			first.created_at,
+			rt.last_used_at,
+			rt.expires_at,
+			COALESCE(rt.user_agent, ''),
+			COALESCE(rt.ip_address, '')
+		FROM refresh_tokens rt
+		JOIN refresh_tokens first ON first.family_id = rt.family_id
+		WHERE rt.user_id = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
+			AND NOT EXISTS (SELECT 1 FROM refresh_tokens p WHERE p.replaced_by = first.token)
+		ORDER BY COALESCE(rt.last_used_at, rt.created_at) DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), dbTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.UserAgent, &session.IPAddress)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// SessionActive reports whether the user's session can still be refreshed,
+// i.e. it hasn't been logged out, revoked for reuse or expired.
+func (c Client) SessionActive(userID uuid.UUID, sessionID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM refresh_tokens
+			WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL AND expires_at > ?
+		)
+	`
+	var active bool
+	err := c.db.QueryRow(query, userID.String(), sessionID, dbTime(time.Now())).Scan(&active)
+	return active, err
+}
+
+// RevokeSession revokes one of the user's sessions. It reports false if the
+// user has no such session.
+func (c Client) RevokeSession(userID uuid.UUID, sessionID string) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL
+	`
+	res, err := c.db.Exec(query, userID.String(), sessionID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeOtherSessions revokes all of the user's sessions except keep and
+// returns how many tokens were revoked.
+func (c Client) RevokeOtherSessions(userID uuid.UUID, keep string) (int64, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id != ? AND revoked_at IS NULL
+	`
+	res, err := c.db.Exec(query, userID.String(), keep)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeAllSessions logs the user out everywhere.
+func (c Client) RevokeAllSessions(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT
+			token,
+			created_at,
+			updated_at,
+			last_used_at,
+			user_id,
+			family_id,
+			expires_at,
+			revoked_at,
+			replaced_by,
+			COALESCE(user_agent, ''),
+			COALESCE(ip_address, '')
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	err := c.db.QueryRow(query, token).Scan(
+		&rt.Token,
+		&rt.CreatedAt,
+		&rt.UpdatedAt,
+		&rt.LastUsedAt,
+		&userID,
+		&rt.FamilyID,
+		&rt.ExpiresAt,
+		&rt.RevokedAt,
+		&rt.ReplacedBy,
+		&rt.UserAgent,
+		&rt.IPAddress,
+	)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload is the persisted state of a resumable upload. The bytes received so
+// far live in the file at FilePath.
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Offset    int64     `json:"offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Length    int64     `json:"length"`
+	Metadata  string    `json:"metadata"`
+	FilePath  string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateUpload(id uuid.UUID, params CreateUploadParams) (Upload, error) {
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		length,
+		upload_offset,
+		metadata,
+		file_path,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id.String(),
+		params.VideoID.String(),
+		params.UserID.String(),
+		params.Length,
+		params.Metadata,
+		params.FilePath,
+		dbTime(params.ExpiresAt),
+	)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return c.GetUpload(id)
+}
+
+const uploadColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		length,
+		upload_offset,
+		metadata,
+		file_path,
+		expires_at
+`
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `SELECT` + uploadColumns + `FROM uploads WHERE id = ?`
+	upload, err := scanUpload(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, nil
+		}
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// GetVideoUploads returns the video's unfinished resumable uploads.
+func (c Client) GetVideoUploads(videoID uuid.UUID) ([]Upload, error) {
+	query := `SELECT` + uploadColumns + `FROM uploads WHERE video_id = ?`
+	return c.queryUploads(query, videoID.String())
+}
+
+func (c Client) queryUploads(query string, args ...any) ([]Upload, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func scanUpload(row rowScanner) (Upload, error) {
+	var upload Upload
+	var id, videoID, userID string
+	err := row.Scan(
+		&id,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&videoID,
+		&userID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Metadata,
+		&upload.FilePath,
+		&upload.ExpiresAt,
+	)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	upload.ID, err = uuid.Parse(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	upload.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return Upload{}, err
+	}
+	upload.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// GetExpiredUploads returns the resumable uploads that expired before now.
+func (c Client) GetExpiredUploads(now time.Time) ([]Upload, error) {
+	query := `SELECT` + uploadColumns + `FROM uploads WHERE expires_at < ?`
+	return c.queryUploads(query, dbTime(now))
+}
+
+// UpdateUploadOffset records how much of an upload has arrived and pushes
+// its expiry back to expiresAt.
+func (c Client) UpdateUploadOffset(id uuid.UUID, offset int64, expiresAt time.Time) error {
+	query := `
+	UPDATE uploads
+	SET upload_offset = ?, expires_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, offset, dbTime(expiresAt), id.String())
+	return err
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
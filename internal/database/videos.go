@@ -2,44 +2,167 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type VideoStatus string
+
+const (
+	VideoDraft      VideoStatus = "draft"
+	VideoUploading  VideoStatus = "uploading"
+	VideoProcessing VideoStatus = "processing"
+	VideoReady      VideoStatus = "ready"
+	VideoFailed     VideoStatus = "failed"
+)
+
+func (s VideoStatus) Valid() bool {
+	switch s {
+	case VideoDraft, VideoUploading, VideoProcessing, VideoReady, VideoFailed:
+		return true
+	}
+	return false
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                  uuid.UUID         `json:"id"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+	ThumbnailURL        *string           `json:"thumbnail_url"`
+	ThumbnailWebPURL    *string           `json:"thumbnail_webp_url"`
+	ThumbnailGenerated  bool              `json:"thumbnail_generated"`
+	ThumbnailVariants   ThumbnailVariants `json:"-"`
+	ThumbnailSrcset     map[string]string `json:"thumbnail_srcset"`
+	VideoURL            *string           `json:"video_url"`
+	HLSURL              *string           `json:"hls_url"`
+	DASHURL             *string           `json:"dash_url"`
+	PreviewsURL         *string           `json:"previews_url"`
+	NeedsReupload       bool              `json:"needs_reupload"`
+	Status              VideoStatus       `json:"status"`
+	StatusError         *string           `json:"status_error"`
+	StatusUpdatedAt     *time.Time        `json:"status_updated_at"`
+	ProcessingStartedAt *time.Time        `json:"processing_started_at"`
+	ProcessedAt         *time.Time        `json:"processed_at"`
+	UnpublishedAt       *time.Time        `json:"unpublished_at"`
+	Metadata            *VideoMetadata    `json:"metadata"`
 	CreateVideoParams
 }
 
+// ThumbnailVariant is a resized copy of a video's thumbnail.
+type ThumbnailVariant struct {
+	URL       string `json:"url"`
+	Width     int    `json:"width"`
+	MediaType string `json:"media_type"`
+}
+
+// ThumbnailVariants is stored as a JSON array in its column.
+type ThumbnailVariants []ThumbnailVariant
+
+func (v ThumbnailVariants) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(v)
+	return string(dat), err
+}
+
+func (v *ThumbnailVariants) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), v)
+	case []byte:
+		return json.Unmarshal(src, v)
+	}
+	return fmt.Errorf("can't scan %T into ThumbnailVariants", src)
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_webp_url,
+		thumbnail_generated,
+		thumbnail_variants,
 		video_url,
+		hls_url,
+		dash_url,
+		previews_url,
+		needs_reupload,
+		status,
+		status_error,
+		status_updated_at,
+		processing_started_at,
+		processed_at,
+		unpublished_at,
 		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailWebPURL,
+		&video.ThumbnailGenerated,
+		&video.ThumbnailVariants,
+		&video.VideoURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		&video.PreviewsURL,
+		&video.NeedsReupload,
+		&video.Status,
+		&video.StatusError,
+		&video.StatusUpdatedAt,
+		&video.ProcessingStartedAt,
+		&video.ProcessedAt,
+		&video.UnpublishedAt,
+		&video.UserID,
+	)
+	return video, err
+}
+
+// GetVideos returns the user's videos, newest first, optionally limited to
+// the given statuses.
+func (c Client) GetVideos(userID uuid.UUID, statuses ...VideoStatus) ([]Video, error) {
+	query := `SELECT` + videoColumns + `FROM videos WHERE user_id = ?`
+	args := []any{userID}
+	if len(statuses) > 0 {
+		placeholders := make([]string, len(statuses))
+		for i, status := range statuses {
+			placeholders[i] = "?"
+			args = append(args, status)
+		}
+		query += ` AND status IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	query += ` ORDER BY created_at DESC`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +170,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -75,10 +189,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		status,
+		status_updated_at,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, CURRENT_TIMESTAMP, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, VideoDraft, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -87,30 +203,9 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE id = ?
-	`
+	query := `SELECT` + videoColumns + `FROM videos WHERE id = ?`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,6 +216,31 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// SetVideoStatus moves a video to status, recording errMsg for failures. It
+// is kept separate from UpdateVideo so that edits to a video's other fields
+// never overwrite a status change made by the processing workers.
+func (c Client) SetVideoStatus(id uuid.UUID, status VideoStatus, errMsg *string) error {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		status_error = ?,
+		status_updated_at = CURRENT_TIMESTAMP,
+		processing_started_at = CASE
+			WHEN ? = 'processing' THEN CURRENT_TIMESTAMP
+			ELSE processing_started_at
+		END,
+		processed_at = CASE
+			WHEN ? IN ('ready', 'failed') THEN CURRENT_TIMESTAMP
+			WHEN ? = 'processing' THEN NULL
+			ELSE processed_at
+		END
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, errMsg, status, status, status, id)
+	return err
+}
+
 func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
@@ -128,7 +248,13 @@ func (c Client) UpdateVideo(video Video) error {
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_webp_url = ?,
+		thumbnail_generated = ?,
+		thumbnail_variants = ?,
 		video_url = ?,
+		hls_url = ?,
+		dash_url = ?,
+		needs_reupload = ?,
 		user_id = ?
 	WHERE id = ?
 	`
@@ -138,18 +264,143 @@ func (c Client) UpdateVideo(video Video) error {
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		&video.ThumbnailWebPURL,
+		video.ThumbnailGenerated,
+		video.ThumbnailVariants,
 		&video.VideoURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		video.NeedsReupload,
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
+// SetVideoFiles points a video at its processed files. Like SetVideoStatus
+// it only touches its own columns, so a thumbnail or title changed while the
+// video was processing survives.
+func (c Client) SetVideoFiles(id uuid.UUID, videoURL string, hlsURL, dashURL *string) error {
+	query := `
+	UPDATE videos
+	SET
+		video_url = ?,
+		hls_url = ?,
+		dash_url = ?,
+		previews_url = NULL,
+		needs_reupload = FALSE,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, videoURL, hlsURL, dashURL, id)
+	return err
+}
+
+// SetVideoPreviews points a video at its seek-preview WebVTT track.
+func (c Client) SetVideoPreviews(id uuid.UUID, previewsURL *string) error {
+	query := `
+	UPDATE videos
+	SET previews_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, previewsURL, id)
+	return err
+}
+
+// SetGeneratedThumbnail points a video at a generated thumbnail, unless it
+// already has a custom one. It reports whether the video was updated.
+func (c Client) SetGeneratedThumbnail(id uuid.UUID, jpegURL, webpURL string, variants ThumbnailVariants) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_webp_url = ?,
+		thumbnail_variants = ?,
+		thumbnail_generated = TRUE
+	WHERE id = ? AND (thumbnail_url IS NULL OR thumbnail_generated)
+	`
+	res, err := c.db.Exec(query, jpegURL, webpURL, variants, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetCustomThumbnail points a video at a thumbnail its owner uploaded. It
+// only touches the thumbnail columns, so it can't undo files a processing
+// job stored while the upload was in flight.
+func (c Client) SetCustomThumbnail(id uuid.UUID, url string, variants ThumbnailVariants) error {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_webp_url = NULL,
+		thumbnail_variants = ?,
+		thumbnail_generated = FALSE,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, url, variants, id)
+	return err
+}
+
+// SetVideoUnpublished hides a video from everyone but its owner, or makes it
+// visible again. The API stops handing out its media URLs while it's
+// unpublished, so even the owner only sees its details.
+func (c Client) SetVideoUnpublished(id uuid.UUID, unpublished bool) error {
+	query := `
+	UPDATE videos
+	SET
+		unpublished_at = CASE WHEN ? THEN COALESCE(unpublished_at, CURRENT_TIMESTAMP) ELSE NULL END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, unpublished, id)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_metadata WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err = c.db.Exec(query, id)
 	return err
 }
+
+// FlagVideosWithKey marks every video stored at key, in any bucket, as
+// needing re-upload and clears its video URL. It returns the number of rows
+// changed.
+func (c Client) FlagVideosWithKey(key string) (int64, error) {
+	query := `
+	UPDATE videos
+	SET
+		video_url = NULL,
+		needs_reupload = TRUE,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE video_url LIKE '%,' || ?
+	`
+	res, err := c.db.Exec(query, key)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CountVideosWithKey returns how many videos are stored at key in any bucket.
+func (c Client) CountVideosWithKey(key string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE video_url LIKE '%,' || ?
+	`
+	var count int
+	err := c.db.QueryRow(query, key).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler serves objects from store by key, using the request path (with any
+// mount prefix already stripped) as the key.
+func Handler(store BlobStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		body, info, err := store.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "couldn't read object", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		if seeker, ok := body.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, key, info.LastModified, seeker)
+			return
+		}
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		io.Copy(w, body)
+	})
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// minPartSize is the smallest part S3 accepts for all but the last part.
+	minPartSize = 5 << 20
+	// maxParts is the most parts S3 accepts in one multipart upload.
+	maxParts = 10000
+	// maxObjectSize is the largest object S3 stores.
+	maxObjectSize = 5 << 40
+)
+
+// MultipartConfig controls how S3Store splits large objects. Bodies of at
+// least PartSize bytes that support io.ReaderAt and io.Seeker (such as
+// *os.File) are uploaded in parts; anything else uses a single PutObject.
+type MultipartConfig struct {
+	PartSize    int64
+	Concurrency int
+	MaxRetries  int
+}
+
+func DefaultMultipartConfig() MultipartConfig {
+	return MultipartConfig{
+		PartSize:    16 << 20,
+		Concurrency: 4,
+		MaxRetries:  3,
+	}
+}
+
+type sizedReaderAt interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// putMultipart uploads body in parts, retrying each part independently. If
+// any part still fails the multipart upload is aborted so S3 doesn't keep
+// (and bill for) the orphaned parts.
+func (s *S3Store) putMultipart(ctx context.Context, key string, body io.ReaderAt, size int64, opts PutOptions) error {
+	partSize, err := partSizeFor(s.multipart.PartSize, size)
+	if err != nil {
+		return err
+	}
+
+	createParams := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentType != "" {
+		createParams.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.CacheControl != "" {
+		createParams.CacheControl = aws.String(opts.CacheControl)
+	}
+	created, err := s.client.CreateMultipartUpload(ctx, createParams)
+	if err != nil {
+		return fmt.Errorf("couldn't start multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	parts, err := s.uploadParts(ctx, key, uploadID, body, size, partSize, opts.Progress)
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return err
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return fmt.Errorf("couldn't complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// partSizeFor returns the part size to upload size bytes with: the
+// configured size, raised if the object would otherwise need more than
+// maxParts parts.
+func partSizeFor(configured, size int64) (int64, error) {
+	if size > maxObjectSize {
+		return 0, fmt.Errorf("object of %d bytes is larger than S3 allows", size)
+	}
+	partSize := max(configured, minPartSize)
+	if minimum := (size + maxParts - 1) / maxParts; partSize < minimum {
+		partSize = minimum
+	}
+	return partSize, nil
+}
+
+func (s *S3Store) uploadParts(ctx context.Context, key string, uploadID *string, body io.ReaderAt, size, partSize int64, progress ProgressFunc) ([]types.CompletedPart, error) {
+	numParts := int((size + partSize - 1) / partSize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		parts    = make([]types.CompletedPart, 0, numParts)
+		uploaded int64
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	partNumbers := make(chan int32)
+
+	concurrency := max(s.multipart.Concurrency, 1)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partNumbers {
+				offset := int64(partNumber-1) * partSize
+				length := min(partSize, size-offset)
+
+				etag, err := s.uploadPartWithRetry(ctx, key, uploadID, partNumber, body, offset, length)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("couldn't upload part %d: %w", partNumber, err)
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				parts = append(parts, types.CompletedPart{
+					ETag:       etag,
+					PartNumber: aws.Int32(partNumber),
+				})
+				uploaded += length
+				if progress != nil {
+					progress(uploaded, size)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 1; i <= numParts; i++ {
+		select {
+		case partNumbers <- int32(i):
+		case <-ctx.Done():
+		}
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+	return parts, nil
+}
+
+func (s *S3Store) uploadPartWithRetry(ctx context.Context, key string, uploadID *string, partNumber int32, body io.ReaderAt, offset, length int64) (*string, error) {
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= s.multipart.MaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying part %d of %s (attempt %d): %v", partNumber, key, attempt+1, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		var out *s3.UploadPartOutput
+		out, err = s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          io.NewSectionReader(body, offset, length),
+			ContentLength: aws.Int64(length),
+		})
+		if err == nil {
+			return out.ETag, nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// abortMultipart discards an unfinished multipart upload. It deliberately
+// ignores the request context, which is likely already canceled.
+func (s *S3Store) abortMultipart(key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("Couldn't abort multipart upload of %s: %v", key, err)
+	}
+}
+
+// AbortIncompleteUploads aborts multipart uploads started more than olderThan
+// ago, cleaning up after processes that died mid-upload. It returns how many
+// uploads were aborted.
+func (s *S3Store) AbortIncompleteUploads(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	aborted := 0
+
+	params := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+	}
+	for {
+		page, err := s.client.ListMultipartUploads(ctx, params)
+		if err != nil {
+			return aborted, err
+		}
+		for _, upload := range page.Uploads {
+			if aws.ToTime(upload.Initiated).After(cutoff) {
+				continue
+			}
+			_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				return aborted, err
+			}
+			aborted++
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return aborted, nil
+		}
+		params.KeyMarker = page.NextKeyMarker
+		params.UploadIdMarker = page.NextUploadIdMarker
+	}
+}
@@ -0,0 +1,35 @@
+package storage
+
+import "testing"
+
+func TestPartSizeFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured int64
+		size       int64
+		want       int64
+		wantErr    bool
+	}{
+		{"fits configured size", 16 << 20, 1 << 30, 16 << 20, false},
+		{"exactly max parts", 5 << 20, 5 << 20 * maxParts, 5 << 20, false},
+		{"raised past max parts", 5 << 20, 5<<20*maxParts + 1, 5<<20 + 1, false},
+		{"50 GB at 5 MB", 5 << 20, 50 << 30, (50<<30 + maxParts - 1) / maxParts, false},
+		{"below S3 minimum", 1 << 20, 10 << 20, minPartSize, false},
+		{"larger than S3 allows", 16 << 20, maxObjectSize + 1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := partSizeFor(tt.configured, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("partSizeFor error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("partSizeFor = %d, want %d", got, tt.want)
+			}
+			if err == nil && (tt.size+got-1)/got > maxParts {
+				t.Errorf("%d bytes in %d byte parts needs more than %d parts", tt.size, got, maxParts)
+			}
+		})
+	}
+}
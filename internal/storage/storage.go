@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ProgressFunc is called as an upload advances with the number of bytes
+// stored so far and the total size, or -1 if the size isn't known.
+type ProgressFunc func(uploaded, total int64)
+
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	Progress     ProgressFunc
+}
+
+// BlobStore is the object storage the server keeps uploaded media in. Keys
+// are slash-separated paths relative to the store's bucket.
+type BlobStore interface {
+	Bucket() string
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// progressReader reports bytes read from r to fn.
+type progressReader struct {
+	r     io.Reader
+	fn    ProgressFunc
+	read  int64
+	total int64
+}
+
+// progressReadSeeker keeps a seekable body seekable, which S3 needs to
+// sign and retry requests.
+type progressReadSeeker struct {
+	*progressReader
+	seeker io.Seeker
+	start  int64
+}
+
+func withProgress(r io.Reader, total int64, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return r
+	}
+	pr := &progressReader{r: r, fn: fn, total: total}
+	if seeker, ok := r.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			return &progressReadSeeker{progressReader: pr, seeker: seeker, start: start}
+		}
+	}
+	return pr
+}
+
+func (p *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := p.seeker.Seek(offset, whence)
+	if err == nil {
+		p.read = n - p.start
+	}
+	return n, err
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.fn(p.read, p.total)
+	}
+	return n, err
+}
+
+// readerSize returns how many bytes remain in r if it can tell without
+// consuming it, or -1.
+func readerSize(r io.Reader) int64 {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	_, err = seeker.Seek(cur, io.SeekStart)
+	if err != nil {
+		return -1
+	}
+	return end - cur
+}
+
+// PresignedPost is an HTML form upload: the file must be posted to URL as
+// multipart form data together with Fields.
+type PresignedPost struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+// DirectUploader is implemented by stores that let clients upload straight
+// to the bucket with presigned requests instead of through the server.
+type DirectUploader interface {
+	// PresignPut returns a URL accepting a single PUT of key. The client must
+	// send contentType as its Content-Type and exactly size bytes.
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, error)
+	// PresignPost returns a form upload for key whose policy pins the
+	// content type and caps the file at maxSize bytes.
+	PresignPost(ctx context.Context, key, contentType string, maxSize int64, expires time.Duration) (PresignedPost, error)
+}
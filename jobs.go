@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobProcessVideo = "process_video"
+
+	jobMaxAttempts  = 5
+	jobRetryBackoff = 10 * time.Second
+	jobPollInterval = 5 * time.Second
+)
+
+// permanentError marks a job failure that retrying can't fix, such as an
+// upload that isn't a video.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// processVideoPayload says where the raw upload for a process_video job is:
+// either a file the server spooled under uploadsRoot, or an object staged in
+// the store by a direct upload. AssetName is the name the processed video is
+// stored under, picked once so every attempt writes to the same keys.
+type processVideoPayload struct {
+	SourcePath string `json:"source_path,omitempty"`
+	StagingKey string `json:"staging_key,omitempty"`
+	AssetName  string `json:"asset_name,omitempty"`
+}
+
+// enqueueVideoProcessing records a job to process a video upload and wakes
+// an idle worker.
+func (cfg *apiConfig) enqueueVideoProcessing(video database.Video, payload processVideoPayload) (database.Job, error) {
+	if payload.AssetName == "" {
+		payload.AssetName = getAssetPath("video/mp4")
+	}
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		Type:        jobProcessVideo,
+		VideoID:     video.ID,
+		UserID:      video.UserID,
+		Payload:     string(dat),
+		MaxAttempts: jobMaxAttempts,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	cfg.setVideoStatus(video.ID, database.VideoProcessing, nil)
+	cfg.events.publish(video.ID, videoEvent{Stage: stageQueued})
+
+	select {
+	case cfg.jobWake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// startJobWorkers requeues jobs interrupted by a previous shutdown and starts
+// n workers that run queued jobs until ctx is canceled.
+func (cfg *apiConfig) startJobWorkers(ctx context.Context, n int) error {
+	requeued, err := cfg.db.RequeueProcessingJobs()
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d interrupted jobs", requeued)
+	}
+
+	for i := 0; i < n; i++ {
+		go cfg.jobWorker(ctx)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg.jobClaimMu.Lock()
+		job, err := cfg.db.ClaimJob()
+		cfg.jobClaimMu.Unlock()
+		if err != nil {
+			log.Printf("Couldn't claim job: %v", err)
+		}
+
+		if err != nil || job.ID == uuid.Nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-cfg.jobWake:
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		cfg.runJob(ctx, job)
+	}
+}
+
+func (cfg *apiConfig) runJob(ctx context.Context, job database.Job) {
+	log.Printf("Running job %s (%s, attempt %d/%d)", job.ID, job.Type, job.Attempts, job.MaxAttempts)
+
+	var err error
+	switch job.Type {
+	case jobProcessVideo:
+		err = cfg.runProcessVideoJob(ctx, job)
+	default:
+		err = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if err == nil {
+		err = cfg.db.CompleteJob(job.ID)
+		if err != nil {
+			log.Printf("Couldn't mark job %s done: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Job %s failed: %v", job.ID, err)
+	var permErr permanentError
+	if job.Attempts < job.MaxAttempts && !errors.As(err, &permErr) {
+		backoff := jobRetryBackoff << (job.Attempts - 1)
+		if job.Type == jobProcessVideo {
+			cfg.events.publish(job.VideoID, videoEvent{
+				Stage:   stageRetrying,
+				Message: fmt.Sprintf("attempt %d failed, retrying in %s: %v", job.Attempts, backoff, err),
+			})
+		}
+		err = cfg.db.RetryJob(job.ID, err.Error(), time.Now().Add(backoff))
+		if err != nil {
+			log.Printf("Couldn't requeue job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	dbErr := cfg.db.FailJob(job.ID, err.Error())
+	if dbErr != nil {
+		log.Printf("Couldn't mark job %s failed: %v", job.ID, dbErr)
+	}
+	if job.Type == jobProcessVideo {
+		cfg.setVideoStatus(job.VideoID, database.VideoFailed, err)
+		cfg.events.publish(job.VideoID, videoEvent{Stage: stageFailed, Message: err.Error()})
+		cfg.cleanupProcessVideoSource(ctx, job)
+	}
+}
+
+func (cfg *apiConfig) runProcessVideoJob(ctx context.Context, job database.Job) error {
+	var payload processVideoPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return permanent(errors.New("video no longer exists"))
+	}
+
+	if job.Attempts == 1 {
+		cfg.setVideoStatus(video.ID, database.VideoProcessing, nil)
+	}
+
+	sourcePath := payload.SourcePath
+	if payload.StagingKey != "" {
+		sourcePath, err = cfg.downloadToTemp(ctx, payload.StagingKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(sourcePath)
+	}
+
+	// Jobs queued before asset names were recorded get a new one each try.
+	assetName := payload.AssetName
+	if assetName == "" {
+		assetName = getAssetPath("video/mp4")
+	}
+	_, err = cfg.processVideoUpload(ctx, video, sourcePath, assetName)
+	if err != nil {
+		return err
+	}
+	cfg.setVideoStatus(video.ID, database.VideoReady, nil)
+	cfg.events.publish(video.ID, videoEvent{Stage: stageReady, Percent: 100})
+
+	cfg.cleanupProcessVideoSource(ctx, job)
+	return nil
+}
+
+// cleanupProcessVideoSource removes the raw upload once a job no longer
+// needs it.
+func (cfg *apiConfig) cleanupProcessVideoSource(ctx context.Context, job database.Job) {
+	var payload processVideoPayload
+	err := json.Unmarshal([]byte(job.Payload), &payload)
+	if err != nil {
+		return
+	}
+	if payload.SourcePath != "" {
+		err = os.Remove(payload.SourcePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldn't remove %s: %v", payload.SourcePath, err)
+		}
+	}
+	if payload.StagingKey != "" {
+		err = cfg.store.Delete(ctx, payload.StagingKey)
+		if err != nil {
+			log.Printf("Couldn't remove staged upload %s: %v", payload.StagingKey, err)
+		}
+	}
+}
+
+func (cfg *apiConfig) downloadToTemp(ctx context.Context, key string) (string, error) {
+	body, _, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read %s: %w", key, err)
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	_, err = io.Copy(tempFile, body)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("couldn't download %s: %w", key, err)
+	}
+	err = tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -24,7 +33,18 @@ type apiConfig struct {
 	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client         *s3.Client
+	storageBackend   string
+	store            storage.BlobStore
+	cdn              *cdnConfig
+	uploadsRoot      string
+	uploadLocks      sync.Map
+	jobWake          chan struct{}
+	jobClaimMu       sync.Mutex
+	events           *eventBroker
+	streamingFormats map[string]bool
+	thumbnailAt      thumbnailSelection
+	previewInterval  time.Duration
+	aspectBuckets    []aspectBucket
 }
 
 type thumbnail struct {
@@ -65,13 +85,71 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = "./uploads"
+	}
+
+	jobWorkers := 2
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		jobWorkers, err = strconv.Atoi(v)
+		if err != nil || jobWorkers <= 0 {
+			log.Fatalf("Invalid JOB_WORKERS %q", v)
+		}
+	}
+
+	streamingFormats, err := parseStreamingFormats(os.Getenv("STREAMING_FORMATS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	thumbnailAt, err := parseThumbnailSelection(os.Getenv("THUMBNAIL_AT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	aspectBuckets, err := parseAspectBuckets(os.Getenv("ASPECT_BUCKETS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	previewInterval := 5 * time.Second
+	if v := os.Getenv("PREVIEW_INTERVAL"); v != "" {
+		previewInterval, err = parseTimestamp(v)
+		if err != nil {
+			log.Fatalf("Invalid PREVIEW_INTERVAL %q", v)
+		}
+	}
+
+	// Only accounts that already exist are promoted: nothing verifies the
+	// email a user signs up with, so promoting at signup would hand admin to
+	// whoever registers a listed address first.
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		promoted, err := db.SetUserRoleByEmail(email, database.RoleAdmin)
+		if err != nil {
+			log.Fatalf("Couldn't make %s an admin: %v", email, err)
+		}
+		if promoted > 0 {
+			log.Printf("Made %s an admin", email)
+		}
+	}
+
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+
 	s3Bucket := os.Getenv("S3_BUCKET")
 	if s3Bucket == "" {
 		log.Fatal("S3_BUCKET environment variable is not set")
 	}
 
 	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
+	if s3Region == "" && storageBackend == "s3" {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
@@ -80,20 +158,42 @@ func main() {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	delivery := os.Getenv("VIDEO_DELIVERY")
+	if delivery == "" {
+		delivery = deliveryPresign
+	}
+
+	var cdnCfg *cdnConfig
+	switch delivery {
+	case deliveryPresign:
+	case deliveryCloudFront:
+		cdnCfg, err = newCDNConfig(s3CfDistribution)
+		if err != nil {
+			log.Fatalf("Couldn't configure CloudFront delivery: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown VIDEO_DELIVERY %q", delivery)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
-	// AWS config
-	ctx := context.TODO()
+	store, err := newBlobStore(storageBackend, s3Bucket, s3Region, assetsRoot, port)
+	if err != nil {
+		log.Fatalf("Couldn't configure %s storage: %v", storageBackend, err)
+	}
 
-	AWScfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(s3Region))
+	err = os.MkdirAll(assetsRoot, 0755)
 	if err != nil {
-		log.Fatalf("failed to load AWS configuration, %v", err)
+		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
-	s3Client := s3.NewFromConfig(AWScfg)
+	err = os.MkdirAll(uploadsRoot, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
 
 	cfg := apiConfig{
 		db:               db,
@@ -105,13 +205,23 @@ func main() {
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
-		s3Client:         s3Client,
+		storageBackend:   storageBackend,
+		store:            store,
+		cdn:              cdnCfg,
+		uploadsRoot:      uploadsRoot,
+		jobWake:          make(chan struct{}, 1),
+		events:           newEventBroker(),
+		streamingFormats: streamingFormats,
+		thumbnailAt:      thumbnailAt,
+		previewInterval:  previewInterval,
+		aspectBuckets:    aspectBuckets,
 	}
 
-	err = cfg.ensureAssetsDir()
+	err = cfg.startJobWorkers(context.Background(), jobWorkers)
 	if err != nil {
-		log.Fatalf("Couldn't create assets directory: %v", err)
+		log.Fatalf("Couldn't start job workers: %v", err)
 	}
+	go cfg.sweepExpiredUploads(context.Background())
 
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
@@ -120,20 +230,47 @@ func main() {
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
+	if storageBackend == "memory" {
+		blobHandler := http.StripPrefix("/blobs", storage.Handler(store))
+		mux.Handle("GET /blobs/", noCacheMiddleware(blobHandler))
+	}
+
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", cfg.requireLogin(cfg.handlerSessionsList))
+	mux.HandleFunc("DELETE /api/sessions", cfg.requireLogin(cfg.handlerSessionsRevokeOthers))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.requireLogin(cfg.handlerSessionRevoke))
+	mux.HandleFunc("POST /api/keys", cfg.requireLogin(cfg.handlerAPIKeyCreate))
+	mux.HandleFunc("GET /api/keys", cfg.requireLogin(cfg.handlerAPIKeysList))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", cfg.requireLogin(cfg.handlerAPIKeyRevoke))
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("POST /api/videos", cfg.requireAuth(database.ScopeUpload, cfg.handlerVideoMetaCreate))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.requireAuth(database.ScopeUpload, cfg.handlerUploadThumbnail))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.requireAuth(database.ScopeUpload, cfg.handlerUploadVideo))
+	mux.HandleFunc("POST /api/video_upload/{videoID}/direct", cfg.requireAuth(database.ScopeUpload, cfg.handlerDirectUploadCreate))
+	mux.HandleFunc("POST /api/video_upload/{videoID}/complete", cfg.requireAuth(database.ScopeUpload, cfg.handlerDirectUploadComplete))
+	mux.HandleFunc("OPTIONS /api/video_upload/{videoID}/tus", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/tus", cfg.requireAuth(database.ScopeUpload, cfg.handlerTusCreate))
+	mux.HandleFunc("HEAD /api/video_upload/{videoID}/tus/{uploadID}", cfg.requireAuth(database.ScopeUpload, cfg.handlerTusHead))
+	mux.HandleFunc("PATCH /api/video_upload/{videoID}/tus/{uploadID}", cfg.requireAuth(database.ScopeUpload, cfg.handlerTusPatch))
+	mux.HandleFunc("DELETE /api/video_upload/{videoID}/tus/{uploadID}", cfg.requireAuth(database.ScopeUpload, cfg.handlerTusDelete))
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.requireAuth(database.ScopeRead, cfg.handlerJobGet))
+	mux.HandleFunc("GET /api/videos", cfg.requireAuth(database.ScopeRead, cfg.handlerVideosRetrieve))
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/status", cfg.requireAuth(database.ScopeRead, cfg.handlerVideoStatus))
+	mux.HandleFunc("GET /api/videos/{videoID}/events", cfg.requireAuth(database.ScopeRead, cfg.handlerVideoEvents))
+	mux.HandleFunc("GET /api/videos/{videoID}/stream/{token}/{path...}", cfg.handlerVideoStream)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.requireAuth(database.ScopeDelete, cfg.handlerVideoMetaDelete))
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/users", cfg.requireAdmin(cfg.handlerAdminUsersList))
+	mux.HandleFunc("PATCH /admin/users/{userID}", cfg.requireAdmin(cfg.handlerAdminUserUpdate))
+	mux.HandleFunc("POST /admin/videos/{videoID}/unpublish", cfg.requireAdmin(cfg.handlerAdminVideoUnpublish))
+	mux.HandleFunc("POST /admin/videos/{videoID}/publish", cfg.requireAdmin(cfg.handlerAdminVideoPublish))
+	mux.HandleFunc("DELETE /admin/videos/{videoID}", cfg.requireAdmin(cfg.handlerAdminVideoDelete))
+	mux.HandleFunc("POST /admin/reset", cfg.requireAdmin(cfg.handlerReset))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -143,3 +280,117 @@ func main() {
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// newBlobStore builds the object store selected by STORAGE_BACKEND: "s3"
+// (default), "local" for files under ASSETS_ROOT, or "memory".
+func newBlobStore(backend, bucket, region, assetsRoot, port string) (storage.BlobStore, error) {
+	switch backend {
+	case "s3":
+		AWScfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+		}
+		multipart, err := multipartConfig()
+		if err != nil {
+			return nil, err
+		}
+		store := storage.NewS3Store(s3.NewFromConfig(AWScfg), bucket, multipart)
+		go func() {
+			aborted, err := store.AbortIncompleteUploads(context.Background(), 24*time.Hour)
+			if err != nil {
+				log.Printf("Couldn't clean up incomplete multipart uploads: %v", err)
+				return
+			}
+			if aborted > 0 {
+				log.Printf("Aborted %d incomplete multipart uploads", aborted)
+			}
+		}()
+		return store, nil
+	case "local":
+		return storage.NewLocalStore(
+			filepath.Join(assetsRoot, bucket),
+			bucket,
+			fmt.Sprintf("http://localhost:%s/assets/%s", port, bucket),
+		)
+	case "memory":
+		return storage.NewMemoryStore(bucket, fmt.Sprintf("http://localhost:%s/blobs", port)), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// newCDNConfig reads the optional CloudFront signing settings. Without
+// CF_KEY_PAIR_ID the distribution's URLs are handed out unsigned.
+func newCDNConfig(distribution string) (*cdnConfig, error) {
+	c := &cdnConfig{
+		domain: cdnDomain(distribution),
+		policy: strings.ToLower(strings.TrimSpace(os.Getenv("CF_POLICY"))),
+		ttl:    time.Hour,
+	}
+	switch c.policy {
+	case "", "canned", "custom":
+	default:
+		return nil, fmt.Errorf("invalid CF_POLICY %q: must be canned or custom", c.policy)
+	}
+
+	if ttl := os.Getenv("CF_URL_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CF_URL_TTL: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid CF_URL_TTL %q: must be positive", ttl)
+		}
+		c.ttl = d
+	}
+
+	keyPairID := os.Getenv("CF_KEY_PAIR_ID")
+	if keyPairID == "" {
+		return c, nil
+	}
+	keyPath := os.Getenv("CF_PRIVATE_KEY_PATH")
+	if keyPath == "" {
+		return nil, errors.New("CF_PRIVATE_KEY_PATH must be set when CF_KEY_PAIR_ID is")
+	}
+	key, err := cdn.LoadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	c.signer = cdn.NewSigner(keyPairID, key)
+
+	// Sign a URL now so a key that can't sign fails at startup rather than
+	// on every video read.
+	_, err = c.cdnURL("startup-check")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't sign CloudFront URLs with CF_KEY_PAIR_ID %s: %w", keyPairID, err)
+	}
+	return c, nil
+}
+
+// multipartConfig reads the optional S3 multipart tuning settings.
+func multipartConfig() (storage.MultipartConfig, error) {
+	c := storage.DefaultMultipartConfig()
+
+	if v := os.Getenv("S3_PART_SIZE_MB"); v != "" {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || mb <= 0 {
+			return c, fmt.Errorf("invalid S3_PART_SIZE_MB %q", v)
+		}
+		c.PartSize = mb << 20
+	}
+	if v := os.Getenv("S3_UPLOAD_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("invalid S3_UPLOAD_CONCURRENCY %q", v)
+		}
+		c.Concurrency = n
+	}
+	if v := os.Getenv("S3_PART_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("invalid S3_PART_RETRIES %q", v)
+		}
+		c.MaxRetries = n
+	}
+	return c, nil
+}
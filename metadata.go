@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// aspectBucket is a named aspect ratio that videos are classified into.
+type aspectBucket struct {
+	Name  string
+	Ratio float64
+}
+
+const aspectOther = "other"
+
+// aspectTolerance is how far, relative to the bucket's ratio, a video's
+// aspect ratio may be and still fall into the bucket.
+const aspectTolerance = 0.01
+
+// parseAspectBuckets reads ASPECT_BUCKETS, a comma-separated list of W:H
+// ratios such as "16:9,9:16,4:3,1:1,21:9". It defaults to 16:9 and 9:16.
+func parseAspectBuckets(value string) ([]aspectBucket, error) {
+	if strings.TrimSpace(value) == "" {
+		value = "16:9,9:16"
+	}
+	buckets := []aspectBucket{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		ratio, ok := parseRatio(name)
+		if !ok || ratio <= 0 {
+			return nil, fmt.Errorf("invalid aspect ratio %q in ASPECT_BUCKETS", name)
+		}
+		buckets = append(buckets, aspectBucket{Name: name, Ratio: ratio})
+	}
+	return buckets, nil
+}
+
+// classifyAspectRatio returns the name of the bucket closest to width:height,
+// or "other" if none is within tolerance.
+func classifyAspectRatio(width, height int, buckets []aspectBucket) string {
+	if width <= 0 || height <= 0 {
+		return aspectOther
+	}
+	ratio := float64(width) / float64(height)
+	best, bestDiff := aspectOther, math.Inf(1)
+	for _, b := range buckets {
+		diff := math.Abs(ratio-b.Ratio) / b.Ratio
+		if diff < aspectTolerance && diff < bestDiff {
+			best, bestDiff = b.Name, diff
+		}
+	}
+	return best
+}
+
+// parseRatio reads "a:b" or "a/b", as ffprobe writes aspect and frame rates.
+func parseRatio(s string) (float64, bool) {
+	num, den, ok := strings.Cut(s, ":")
+	if !ok {
+		num, den, ok = strings.Cut(s, "/")
+	}
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, false
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// ffprobeOutput is the part of `ffprobe -show_streams -show_format` output we
+// record.
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Tags       struct {
+			MajorBrand string `json:"major_brand"`
+		} `json:"tags"`
+	} `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecName          string `json:"codec_name"`
+	CodecType          string `json:"codec_type"`
+	Width              int    `json:"width"`
+	Height             int    `json:"height"`
+	SampleAspectRatio  string `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string `json:"display_aspect_ratio"`
+	AvgFrameRate       string `json:"avg_frame_rate"`
+	Channels           int    `json:"channels"`
+	Disposition        struct {
+		Default     int `json:"default"`
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+	Tags struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// probeVideo runs ffprobe on filePath and summarizes what it finds.
+func probeVideo(filePath string, buckets []aspectBucket) (database.VideoMetadata, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return database.VideoMetadata{}, fmt.Errorf("ffprobe failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var probe ffprobeOutput
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return database.VideoMetadata{}, fmt.Errorf("couldn't parse ffprobe output: %w", err)
+	}
+	return summarizeProbe(probe, buckets), nil
+}
+
+// summarizeProbe picks the primary video stream and first audio stream out
+// of probe and classifies the video's aspect ratio as it will be displayed.
+func summarizeProbe(probe ffprobeOutput, buckets []aspectBucket) database.VideoMetadata {
+	metadata := database.VideoMetadata{
+		Container:   probe.Format.FormatName,
+		MajorBrand:  strings.TrimSpace(probe.Format.Tags.MajorBrand),
+		AspectRatio: aspectOther,
+	}
+	metadata.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	metadata.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+
+	if video, ok := primaryVideoStream(probe.Streams); ok {
+		metadata.VideoCodec = video.CodecName
+		metadata.Width = video.Width
+		metadata.Height = video.Height
+		metadata.Rotation = streamRotation(video)
+		metadata.FrameRate, _ = parseRatio(video.AvgFrameRate)
+		width, height := displaySize(video)
+		metadata.AspectRatio = classifyAspectRatio(width, height, buckets)
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			metadata.AudioCodec = stream.CodecName
+			metadata.AudioChannels = stream.Channels
+			break
+		}
+	}
+	return metadata
+}
+
+// primaryVideoStream returns the stream a player would show: the default
+// video stream if one is marked, otherwise the first. Cover art, which
+// ffprobe also reports as a video stream, never counts.
+func primaryVideoStream(streams []ffprobeStream) (ffprobeStream, bool) {
+	var first *ffprobeStream
+	for i, stream := range streams {
+		if stream.CodecType != "video" || stream.Disposition.AttachedPic != 0 || stream.Width == 0 || stream.Height == 0 {
+			continue
+		}
+		if stream.Disposition.Default != 0 {
+			return stream, true
+		}
+		if first == nil {
+			first = &streams[i]
+		}
+	}
+	if first == nil {
+		return ffprobeStream{}, false
+	}
+	return *first, true
+}
+
+// displaySize returns the size a stream is shown at: its coded size
+// stretched by the display (or sample) aspect ratio, then turned by its
+// rotation.
+func displaySize(stream ffprobeStream) (int, int) {
+	width, height := stream.Width, stream.Height
+	if dar, ok := parseRatio(stream.DisplayAspectRatio); ok && dar > 0 {
+		width = int(math.Round(float64(height) * dar))
+	} else if sar, ok := parseRatio(stream.SampleAspectRatio); ok && sar > 0 {
+		width = int(math.Round(float64(width) * sar))
+	}
+
+	rotation := ((streamRotation(stream) % 360) + 360) % 360
+	if rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// streamRotation returns the rotation in degrees recorded on a stream, from
+// its display matrix or the older rotate tag.
+func streamRotation(stream ffprobeStream) int {
+	for _, sideData := range stream.SideDataList {
+		if sideData.SideDataType == "Display Matrix" {
+			return int(math.Round(sideData.Rotation))
+		}
+	}
+	rotation, _ := strconv.Atoi(stream.Tags.Rotate)
+	return rotation
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSummarizeProbe(t *testing.T) {
+	buckets, err := parseAspectBuckets("16:9,9:16,4:3,1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fixture     string
+		videoCodec  string
+		width       int
+		height      int
+		rotation    int
+		aspectRatio string
+	}{
+		{"audio_first.json", "h264", 1920, 1080, 0, "16:9"},
+		{"cover_art.json", "h264", 1280, 720, 0, "16:9"},
+		{"rotation_minus90.json", "hevc", 1920, 1080, -90, "9:16"},
+		{"rotation_90.json", "hevc", 1920, 1080, 90, "9:16"},
+		{"rotate_tag.json", "h264", 1280, 720, 90, "9:16"},
+		{"anamorphic.json", "h264", 1440, 1080, 0, "16:9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			dat, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var probe ffprobeOutput
+			err = json.Unmarshal(dat, &probe)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := summarizeProbe(probe, buckets)
+			if got.VideoCodec != tt.videoCodec {
+				t.Errorf("VideoCodec = %q, want %q", got.VideoCodec, tt.videoCodec)
+			}
+			if got.Width != tt.width || got.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", got.Width, got.Height, tt.width, tt.height)
+			}
+			if got.Rotation != tt.rotation {
+				t.Errorf("Rotation = %d, want %d", got.Rotation, tt.rotation)
+			}
+			if got.AspectRatio != tt.aspectRatio {
+				t.Errorf("AspectRatio = %q, want %q", got.AspectRatio, tt.aspectRatio)
+			}
+		})
+	}
+}
+
+func TestDisplaySize(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream ffprobeStream
+		width  int
+		height int
+	}{
+		{
+			name:   "square pixels",
+			stream: ffprobeStream{Width: 1920, Height: 1080, SampleAspectRatio: "1:1", DisplayAspectRatio: "16:9"},
+			width:  1920,
+			height: 1080,
+		},
+		{
+			name:   "anamorphic DAR",
+			stream: ffprobeStream{Width: 1440, Height: 1080, SampleAspectRatio: "4:3", DisplayAspectRatio: "16:9"},
+			width:  1920,
+			height: 1080,
+		},
+		{
+			name:   "anamorphic SAR only",
+			stream: ffprobeStream{Width: 720, Height: 576, SampleAspectRatio: "64:45"},
+			width:  1024,
+			height: 576,
+		},
+		{
+			name:   "unknown aspect",
+			stream: ffprobeStream{Width: 640, Height: 480, SampleAspectRatio: "0:1", DisplayAspectRatio: "0:1"},
+			width:  640,
+			height: 480,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := displaySize(tt.stream)
+			if width != tt.width || height != tt.height {
+				t.Errorf("displaySize = %dx%d, want %dx%d", width, height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestPrimaryVideoStream(t *testing.T) {
+	audio := ffprobeStream{CodecName: "aac", CodecType: "audio"}
+	cover := ffprobeStream{CodecName: "mjpeg", CodecType: "video", Width: 600, Height: 600}
+	cover.Disposition.Default = 1
+	cover.Disposition.AttachedPic = 1
+	video := ffprobeStream{CodecName: "h264", CodecType: "video", Width: 1280, Height: 720}
+	alt := ffprobeStream{CodecName: "vp9", CodecType: "video", Width: 640, Height: 360}
+	defaultAlt := alt
+	defaultAlt.Disposition.Default = 1
+
+	tests := []struct {
+		name    string
+		streams []ffprobeStream
+		want    string
+		ok      bool
+	}{
+		{"audio first", []ffprobeStream{audio, video}, "h264", true},
+		{"cover art first", []ffprobeStream{cover, video, audio}, "h264", true},
+		{"first video without default", []ffprobeStream{video, alt}, "h264", true},
+		{"default video wins", []ffprobeStream{video, defaultAlt}, "vp9", true},
+		{"only cover art", []ffprobeStream{cover, audio}, "", false},
+		{"no streams", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := primaryVideoStream(tt.streams)
+			if ok != tt.ok || got.CodecName != tt.want {
+				t.Errorf("primaryVideoStream = %q, %v, want %q, %v", got.CodecName, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// principal is who a request is acting as. SessionID is set for logins and
+// APIKey for requests authenticated with a key.
+type principal struct {
+	UserID    uuid.UUID
+	Role      database.Role
+	SessionID string
+	APIKey    *database.APIKey
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller stored by requireAuth or requireLogin.
+func principalFrom(r *http.Request) principal {
+	p, _ := r.Context().Value(principalKey{}).(principal)
+	return p
+}
+
+// requireAuth authenticates the request with either a Bearer JWT or an
+// ApiKey header before calling next. The user's role must allow scope, and
+// API keys must also have been granted it.
+func (cfg *apiConfig) requireAuth(scope database.APIKeyScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p principal
+		var ok bool
+		if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+			p, ok = cfg.authenticateAPIKey(w, r, scope)
+		} else {
+			p, ok = cfg.authenticateJWT(w, r)
+		}
+		if !ok {
+			return
+		}
+		if !p.Role.Allows(scope) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("The %s role doesn't have the %s scope", p.Role, scope), nil)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// requireLogin is requireAuth for endpoints that manage the account itself,
+// which API keys may not use.
+func (cfg *apiConfig) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := cfg.authenticateJWT(w, r)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// requireAdmin is requireLogin for endpoints only admins may use.
+func (cfg *apiConfig) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		if principalFrom(r).Role != database.RoleAdmin {
+			respondWithError(w, http.StatusForbidden, "Admins only", nil)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (cfg *apiConfig) authenticateJWT(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return principal{}, false
+	}
+	claims, err := auth.ParseAccessToken(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return principal{}, false
+	}
+	p := principal{
+		UserID:    claims.UserID,
+		Role:      database.Role(claims.Role),
+		SessionID: claims.SessionID,
+	}
+
+	// Access tokens outlive a logout unless we check that the session they
+	// were issued for is still live. Disabling an account or changing its
+	// role revokes its sessions too, so the role claim can be trusted.
+	if p.SessionID != "" {
+		active, err := cfg.db.SessionActive(p.UserID, p.SessionID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get session", err)
+			return principal{}, false
+		}
+		if !active {
+			respondWithError(w, http.StatusUnauthorized, "Session has been revoked", nil)
+			return principal{}, false
+		}
+	}
+
+	// Tokens from before sessions or roles existed carry neither, so fall
+	// back to the account itself.
+	if p.SessionID == "" || p.Role == "" {
+		user, ok := cfg.getActiveUser(w, p.UserID)
+		if !ok {
+			return principal{}, false
+		}
+		p.Role = user.Role
+	}
+	return p, true
+}
+
+// getActiveUser loads a user who is acting with credentials issued earlier,
+// writing a 401 if the account has since been deleted or disabled.
+func (cfg *apiConfig) getActiveUser(w http.ResponseWriter, userID uuid.UUID) (*database.User, bool) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return nil, false
+	}
+	if user == nil || user.DisabledAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Account is disabled", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+func (cfg *apiConfig) authenticateAPIKey(w http.ResponseWriter, r *http.Request, scope database.APIKeyScope) (principal, bool) {
+	secret, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+		return principal{}, false
+	}
+	key, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(secret))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return principal{}, false
+	}
+	if key.ID == uuid.Nil || key.RevokedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+		return principal{}, false
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "API key has expired", nil)
+		return principal{}, false
+	}
+	if !key.HasScope(scope) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("API key doesn't have the %s scope", scope), nil)
+		return principal{}, false
+	}
+
+	user, ok := cfg.getActiveUser(w, key.UserID)
+	if !ok {
+		return principal{}, false
+	}
+
+	err = cfg.db.TouchAPIKey(key.ID)
+	if err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", key.ID, err)
+	}
+	return principal{UserID: key.UserID, Role: user.Role, APIKey: &key}, true
+}
+
+// getOwnedVideo loads the video in the path and checks the caller owns it,
+// writing a 400, 404 or 403 if not. It must run behind requireAuth.
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	video, ok := cfg.getPathVideo(w, r)
+	if !ok {
+		return database.Video{}, false
+	}
+	if video.UserID != principalFrom(r).UserID {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// getPathVideo loads the video in the path regardless of who owns it,
+// writing a 400 or 404 if there isn't one.
+func (cfg *apiConfig) getPathVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
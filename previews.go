@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	previewDir        = "previews"
+	previewVTT        = "thumbnails.vtt"
+	previewTileWidth  = 160
+	previewColumns    = 10
+	previewRows       = 10
+	previewSpriteName = "sprite-%03d.jpg"
+)
+
+// spriteLayout describes how sampled frames are tiled into sprite sheets.
+type spriteLayout struct {
+	Interval   time.Duration
+	Duration   float64
+	TileWidth  int
+	TileHeight int
+	Columns    int
+	Rows       int
+}
+
+// frames is how many frames are sampled from the video.
+func (l spriteLayout) frames() int {
+	return int(math.Ceil(l.Duration / l.Interval.Seconds()))
+}
+
+// webVTT maps each sampled interval to its tile, as a media fragment of the
+// sprite sheet that holds it.
+func (l spriteLayout) webVTT() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	perSheet := l.Columns * l.Rows
+	step := l.Interval.Seconds()
+	for i := range l.frames() {
+		start := float64(i) * step
+		end := math.Min(start+step, l.Duration)
+		tile := i % perSheet
+		fmt.Fprintf(&b, "\n%s --> %s\n", vttTimestamp(start), vttTimestamp(end))
+		fmt.Fprintf(&b, previewSpriteName+"#xywh=%d,%d,%d,%d\n",
+			i/perSheet+1,
+			(tile%l.Columns)*l.TileWidth,
+			(tile/l.Columns)*l.TileHeight,
+			l.TileWidth,
+			l.TileHeight,
+		)
+	}
+	return b.String()
+}
+
+func vttTimestamp(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		int(d.Hours()),
+		int(d.Minutes())%60,
+		int(d.Seconds())%60,
+		d.Milliseconds()%1000,
+	)
+}
+
+// generateSprites samples a frame of filePath every interval, tiles them into
+// sprite sheets in outDir and writes the WebVTT track that indexes them.
+func generateSprites(ctx context.Context, filePath, outDir string, interval time.Duration) error {
+	src, err := probeSource(filePath)
+	if err != nil {
+		return fmt.Errorf("couldn't probe source: %w", err)
+	}
+	duration, err := getVideoDuration(filePath)
+	if err != nil {
+		return fmt.Errorf("couldn't get duration: %w", err)
+	}
+
+	tileHeight := int(math.Round(float64(previewTileWidth)*float64(src.Height)/float64(src.Width)/2)) * 2
+	layout := spriteLayout{
+		Interval:   interval,
+		Duration:   duration,
+		TileWidth:  previewTileWidth,
+		TileHeight: max(tileHeight, 2),
+		Columns:    previewColumns,
+		Rows:       previewRows,
+	}
+
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d",
+		strconv.FormatFloat(interval.Seconds(), 'f', -1, 64),
+		layout.TileWidth, layout.TileHeight,
+		layout.Columns, layout.Rows,
+	)
+	err = runFFmpeg(ctx, filePath, nil,
+		"-i", filePath,
+		"-vf", filter,
+		"-an",
+		"-q:v", "4",
+		filepath.Join(outDir, previewSpriteName),
+	)
+	if err != nil {
+		return fmt.Errorf("couldn't tile frames: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(outDir, previewVTT), []byte(layout.webVTT()), 0o644)
+}
+
+// processVideoPreviews stores seek-preview sprite sheets and their WebVTT
+// track under prefix and points the video at the track.
+func (cfg *apiConfig) processVideoPreviews(ctx context.Context, video database.Video, filePath, prefix string) (database.Video, error) {
+	cfg.events.publish(video.ID, videoEvent{Stage: stagePreviews})
+
+	outDir, err := os.MkdirTemp("", "tubely-previews-")
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer os.RemoveAll(outDir)
+
+	err = generateSprites(ctx, filePath, outDir, cfg.previewInterval)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	previewPrefix := path.Join(prefix, previewDir)
+	err = cfg.uploadDir(ctx, outDir, previewPrefix)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	previewsURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), path.Join(previewPrefix, previewVTT))
+	err = cfg.db.SetVideoPreviews(video.ID, &previewsURL)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't save previews url: %w", err)
+	}
+	video.PreviewsURL = &previewsURL
+	return video, nil
+}
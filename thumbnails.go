@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// thumbnailSelection says which frame of a video becomes its generated
+// thumbnail: a fixed timestamp, or the most representative frame ffmpeg's
+// thumbnail filter can find.
+type thumbnailSelection struct {
+	Scene bool
+	At    time.Duration
+}
+
+// sceneSampleFrames is how many consecutive frames the thumbnail filter
+// compares when picking the most representative one.
+const sceneSampleFrames = "300"
+
+// parseThumbnailSelection reads THUMBNAIL_AT: "scene" (the default) for
+// scene-based selection, or a timestamp as a duration ("5s", "1m30s") or in
+// seconds.
+func parseThumbnailSelection(value string) (thumbnailSelection, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	if value == "" || value == "scene" {
+		return thumbnailSelection{Scene: true}, nil
+	}
+	at, err := parseTimestamp(value)
+	if err != nil {
+		return thumbnailSelection{}, fmt.Errorf("invalid THUMBNAIL_AT %q: want \"scene\" or a timestamp", value)
+	}
+	return thumbnailSelection{At: at}, nil
+}
+
+// parseTimestamp reads a non-negative duration given either in Go syntax
+// ("5s", "1m30s") or as plain seconds.
+func parseTimestamp(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil {
+			return 0, err
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", value)
+	}
+	return d, nil
+}
+
+// extractThumbnail writes the selected frame of filePath into outDir as
+// thumbnail.jpg and thumbnail.webp and returns their paths.
+func extractThumbnail(ctx context.Context, filePath, outDir string, sel thumbnailSelection) (string, string, error) {
+	jpegPath := filepath.Join(outDir, "thumbnail.jpg")
+	webpPath := filepath.Join(outDir, "thumbnail.webp")
+
+	duration, err := getVideoDuration(filePath)
+	if err != nil {
+		duration = 0
+	}
+
+	var args []string
+	if sel.Scene {
+		// Start a little way in so fades from black at the very start
+		// don't win.
+		if duration > 0 {
+			args = append(args, "-ss", strconv.FormatFloat(duration/10, 'f', 3, 64))
+		}
+		args = append(args, "-i", filePath, "-vf", "thumbnail="+sceneSampleFrames)
+	} else {
+		at := sel.At.Seconds()
+		if duration > 0 && at >= duration {
+			at = duration / 2
+		}
+		args = append(args, "-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", filePath)
+	}
+	args = append(args, "-frames:v", "1", "-q:v", "2", jpegPath)
+
+	err = runFFmpeg(ctx, filePath, nil, args...)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't extract frame: %w", err)
+	}
+
+	err = runFFmpeg(ctx, jpegPath, nil, "-i", jpegPath, "-c:v", "libwebp", "-quality", "80", webpPath)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't encode webp: %w", err)
+	}
+	return jpegPath, webpPath, nil
+}
+
+// processVideoThumbnail generates a thumbnail for the video from filePath and
+// stores it under prefix, unless the owner has uploaded a custom one.
+func (cfg *apiConfig) processVideoThumbnail(ctx context.Context, video database.Video, filePath, prefix string) (database.Video, error) {
+	if video.ThumbnailURL != nil && !video.ThumbnailGenerated {
+		return video, nil
+	}
+	cfg.events.publish(video.ID, videoEvent{Stage: stageThumbnail})
+
+	outDir, err := os.MkdirTemp("", "tubely-thumbnail-")
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer os.RemoveAll(outDir)
+
+	jpegPath, webpPath, err := extractThumbnail(ctx, filePath, outDir, cfg.thumbnailAt)
+	if err != nil {
+		return database.Video{}, err
+	}
+	jpegKey := path.Join(prefix, filepath.Base(jpegPath))
+	webpKey := path.Join(prefix, filepath.Base(webpPath))
+
+	// Variants go in a directory named after the image, so they're uploaded
+	// along with it.
+	variants, err := generateThumbnailVariants(ctx, jpegPath, filepath.Join(outDir, "thumbnail"))
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	err = cfg.uploadDir(ctx, outDir, prefix)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	jpegURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), jpegKey)
+	webpURL := fmt.Sprintf("%s,%s", cfg.store.Bucket(), webpKey)
+	storedVariants := cfg.storedThumbnailVariants(variants, videoPrefix(jpegKey))
+	set, err := cfg.db.SetGeneratedThumbnail(video.ID, jpegURL, webpURL, storedVariants)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't save thumbnail url: %w", err)
+	}
+	if !set {
+		log.Printf("Video %s got a custom thumbnail during processing, keeping it", video.ID)
+		return video, nil
+	}
+	video.ThumbnailURL = &jpegURL
+	video.ThumbnailWebPURL = &webpURL
+	video.ThumbnailVariants = storedVariants
+	video.ThumbnailGenerated = true
+	return video, nil
+}
+
+// thumbnailVariantWidths are the widths thumbnails are resized to for
+// responsive images. Widths larger than the source are skipped.
+var thumbnailVariantWidths = []int{160, 320, 640, 1280}
+
+// thumbnailVariantFile is a resized thumbnail written by
+// generateThumbnailVariants, named relative to its output directory.
+type thumbnailVariantFile struct {
+	Name      string
+	Width     int
+	MediaType string
+}
+
+// generateThumbnailVariants writes JPEG and WebP copies of the image at
+// srcPath into outDir at each of thumbnailVariantWidths, as "<width>w.jpg"
+// and "<width>w.webp".
+func generateThumbnailVariants(ctx context.Context, srcPath, outDir string) ([]thumbnailVariantFile, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read thumbnail size: %w", err)
+	}
+
+	widths := []int{}
+	for _, w := range thumbnailVariantWidths {
+		if w <= config.Width {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		widths = append(widths, config.Width)
+	}
+
+	err = os.MkdirAll(outDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := []thumbnailVariantFile{}
+	for _, w := range widths {
+		scale := fmt.Sprintf("scale=%d:-2", w)
+		jpegName := fmt.Sprintf("%dw.jpg", w)
+		webpName := fmt.Sprintf("%dw.webp", w)
+		err = runFFmpeg(ctx, srcPath, nil,
+			"-i", srcPath,
+			"-vf", scale, "-q:v", "3", filepath.Join(outDir, jpegName),
+			"-vf", scale, "-c:v", "libwebp", "-quality", "80", filepath.Join(outDir, webpName),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resize thumbnail to %dw: %w", w, err)
+		}
+		variants = append(variants,
+			thumbnailVariantFile{Name: jpegName, Width: w, MediaType: "image/jpeg"},
+			thumbnailVariantFile{Name: webpName, Width: w, MediaType: "image/webp"},
+		)
+	}
+	return variants, nil
+}
+
+// storedThumbnailVariants records variant files uploaded under prefix as
+// "bucket,key" values.
+func (cfg *apiConfig) storedThumbnailVariants(files []thumbnailVariantFile, prefix string) database.ThumbnailVariants {
+	variants := database.ThumbnailVariants{}
+	for _, f := range files {
+		variants = append(variants, database.ThumbnailVariant{
+			URL:       fmt.Sprintf("%s,%s", cfg.store.Bucket(), path.Join(prefix, f.Name)),
+			Width:     f.Width,
+			MediaType: f.MediaType,
+		})
+	}
+	return variants
+}
+
+// storeThumbnailVariants resizes the image at srcPath, stores the variants
+// next to the image at key, in a directory named after it, and returns them.
+func (cfg *apiConfig) storeThumbnailVariants(ctx context.Context, srcPath, key string) (database.ThumbnailVariants, error) {
+	outDir, err := os.MkdirTemp("", "tubely-variants-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	files, err := generateThumbnailVariants(ctx, srcPath, outDir)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.uploadDir(ctx, outDir, videoPrefix(key))
+	if err != nil {
+		return nil, err
+	}
+	return cfg.storedThumbnailVariants(files, videoPrefix(key)), nil
+}
+
+// thumbnailSrcset turns a video's thumbnail variants into srcset attribute
+// values keyed by media type, signing each URL.
+func (cfg *apiConfig) thumbnailSrcset(variants database.ThumbnailVariants) (map[string]string, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	srcset := map[string]string{}
+	for _, v := range variants {
+		url, err := cfg.signStoredURL(v.URL)
+		if err != nil {
+			return nil, err
+		}
+		entry := fmt.Sprintf("%s %dw", url, v.Width)
+		if srcset[v.MediaType] != "" {
+			entry = srcset[v.MediaType] + ", " + entry
+		}
+		srcset[v.MediaType] = entry
+	}
+	return srcset, nil
+}
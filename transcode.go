@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	formatHLS  = "hls"
+	formatDASH = "dash"
+
+	// sharedStreamDir holds the fMP4 segments and both manifests when HLS
+	// and DASH are produced together.
+	sharedStreamDir = "cmaf"
+)
+
+// parseStreamingFormats reads STREAMING_FORMATS, a comma-separated list of
+// adaptive streaming formats ("hls", "dash") to produce. It defaults to HLS;
+// "none" turns transcoding off.
+func parseStreamingFormats(value string) (map[string]bool, error) {
+	formats := map[string]bool{}
+	if value == "" {
+		value = formatHLS
+	}
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(strings.ToLower(f))
+		switch f {
+		case "none", "":
+		case formatHLS, formatDASH:
+			formats[f] = true
+		default:
+			return nil, fmt.Errorf("unknown streaming format %q in STREAMING_FORMATS", f)
+		}
+	}
+	return formats, nil
+}
+
+// rendition is one step of the adaptive bitrate ladder. Height is the short
+// side of the frame, so portrait videos get the same quality steps.
+type rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate string
+	MaxRate      string
+	BufSize      string
+	AudioBitrate string
+}
+
+var renditionLadder = []rendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: "5000k", MaxRate: "5350k", BufSize: "7500k", AudioBitrate: "192k"},
+	{Name: "720p", Height: 720, VideoBitrate: "2800k", MaxRate: "2996k", BufSize: "4200k", AudioBitrate: "128k"},
+	{Name: "480p", Height: 480, VideoBitrate: "1400k", MaxRate: "1498k", BufSize: "2100k", AudioBitrate: "128k"},
+	{Name: "360p", Height: 360, VideoBitrate: "800k", MaxRate: "856k", BufSize: "1200k", AudioBitrate: "96k"},
+}
+
+// segmentSeconds is the segment length for both HLS and DASH.
+const segmentSeconds = "6"
+
+// sourceInfo is what the transcoder needs to know about its input.
+type sourceInfo struct {
+	Width    int
+	Height   int
+	HasAudio bool
+}
+
+// probeSource reads the display size of the primary video stream, which is
+// what ffmpeg scales from once it has applied any rotation.
+func probeSource(filePath string) (sourceInfo, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
+	out, err := cmd.Output()
+	if err != nil {
+		return sourceInfo{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe ffprobeOutput
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return sourceInfo{}, err
+	}
+
+	video, ok := primaryVideoStream(probe.Streams)
+	if !ok {
+		return sourceInfo{}, fmt.Errorf("no video stream in %s", filePath)
+	}
+	info := sourceInfo{}
+	info.Width, info.Height = displaySize(video)
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			info.HasAudio = true
+		}
+	}
+	return info, nil
+}
+
+// ladderFor returns the renditions that don't upscale the source. Sources
+// smaller than the lowest step get a single rendition at their own size.
+func ladderFor(src sourceInfo) []rendition {
+	shortSide := min(src.Width, src.Height)
+	ladder := []rendition{}
+	for _, r := range renditionLadder {
+		if r.Height <= shortSide {
+			ladder = append(ladder, r)
+		}
+	}
+	if len(ladder) == 0 {
+		lowest := renditionLadder[len(renditionLadder)-1]
+		lowest.Name = fmt.Sprintf("%dp", shortSide)
+		lowest.Height = shortSide - shortSide%2
+		ladder = append(ladder, lowest)
+	}
+	return ladder
+}
+
+// ladderArgs returns the ffmpeg filter graph, stream mappings and encoder
+// settings shared by every output of the ladder.
+func ladderArgs(src sourceInfo, ladder []rendition) []string {
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(ladder))
+	for i := range ladder {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	for i, r := range ladder {
+		scale := fmt.Sprintf("scale=w=-2:h=%d", r.Height)
+		if src.Width < src.Height {
+			scale = fmt.Sprintf("scale=w=%d:h=-2", r.Height)
+		}
+		fmt.Fprintf(&filter, ";[v%d]%s[v%dout]", i, scale, i)
+	}
+
+	args := []string{"-filter_complex", filter.String()}
+	for i, r := range ladder {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264",
+			fmt.Sprintf("-b:v:%d", i), r.VideoBitrate,
+			fmt.Sprintf("-maxrate:v:%d", i), r.MaxRate,
+			fmt.Sprintf("-bufsize:v:%d", i), r.BufSize,
+		)
+	}
+	if src.HasAudio {
+		for i, r := range ladder {
+			args = append(args,
+				"-map", "a:0",
+				fmt.Sprintf("-c:a:%d", i), "aac",
+				fmt.Sprintf("-b:a:%d", i), r.AudioBitrate,
+				fmt.Sprintf("-ac:a:%d", i), "2",
+			)
+		}
+	}
+	// Keyframes on segment boundaries so every rendition can switch there.
+	args = append(args,
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-sc_threshold", "0",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%s)", segmentSeconds),
+	)
+	return args
+}
+
+// transcodeHLS encodes filePath into an HLS ladder under outDir, with one
+// directory per rendition and master.m3u8 at the top.
+func transcodeHLS(ctx context.Context, filePath, outDir string, src sourceInfo, progress ffmpegProgressFunc) error {
+	ladder := ladderFor(src)
+
+	streamMap := make([]string, len(ladder))
+	for i, r := range ladder {
+		err := os.MkdirAll(filepath.Join(outDir, r.Name), 0755)
+		if err != nil {
+			return err
+		}
+		if src.HasAudio {
+			streamMap[i] = fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.Name)
+		} else {
+			streamMap[i] = fmt.Sprintf("v:%d,name:%s", i, r.Name)
+		}
+	}
+
+	args := []string{"-i", filePath}
+	args = append(args, ladderArgs(src, ladder)...)
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", segmentSeconds,
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(outDir, "%v", "segment_%04d.ts"),
+		"-master_pl_name", "master.m3u8",
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outDir, "%v", "index.m3u8"),
+	)
+	return runFFmpeg(ctx, filePath, progress, args...)
+}
+
+// transcodeDASH encodes filePath into a DASH presentation with fragmented
+// MP4 segments under outDir, using the same ladder as HLS, with manifest.mpd
+// at the top. With withHLS the muxer also writes HLS playlists for the same
+// segments, with master.m3u8 at the top, so both formats share one encode.
+func transcodeDASH(ctx context.Context, filePath, outDir string, src sourceInfo, withHLS bool, progress ffmpegProgressFunc) error {
+	ladder := ladderFor(src)
+
+	adaptationSets := "id=0,streams=v"
+	if src.HasAudio {
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args := []string{"-i", filePath}
+	args = append(args, ladderArgs(src, ladder)...)
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", segmentSeconds,
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+	)
+	if withHLS {
+		args = append(args, "-hls_playlist", "1")
+	}
+	args = append(args, filepath.Join(outDir, "manifest.mpd"))
+	return runFFmpeg(ctx, filePath, progress, args...)
+}
+
+// uploadDir stores every file under dir at prefix plus its relative path.
+func (cfg *apiConfig) uploadDir(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		key := path.Join(prefix, filepath.ToSlash(rel))
+		err = cfg.store.Put(ctx, key, file, storage.PutOptions{
+			ContentType:  streamingContentType(p),
+			CacheControl: "public, max-age=31536000",
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't upload %s: %w", key, err)
+		}
+		return nil
+	})
+}
+
+func streamingContentType(filePath string) string {
+	switch filepath.Ext(filePath) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".vtt":
+		return "text/vtt"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(filePath)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}